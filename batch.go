@@ -0,0 +1,172 @@
+package redimo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	batchGetSize    = 100
+	batchWriteSize  = 25
+	batchMinBackoff = 10 * time.Millisecond
+	batchMaxBackoff = time.Second
+)
+
+// batchGetItems fetches the items with the given keys using BatchGetItem, in chunks of up to 100 keys. Keys that
+// DynamoDB leaves unprocessed are retried with an exponential backoff. Items that don't exist are simply not
+// returned, and the order of the items is not related to the order of the keys. The keys must be unique.
+func (c Client) batchGetItems(keys []map[string]types.AttributeValue) (items []map[string]types.AttributeValue, err error) {
+	for start := 0; start < len(keys); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		requestItems := map[string]types.KeysAndAttributes{
+			c.tableName: {
+				ConsistentRead: aws.Bool(c.consistentReads),
+				Keys:           keys[start:end],
+			},
+		}
+
+		backoff := batchMinBackoff
+
+		for len(requestItems) > 0 {
+			resp, err := c.ddbClient.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return items, err
+			}
+
+			items = append(items, resp.Responses[c.tableName]...)
+
+			requestItems = resp.UnprocessedKeys
+			if len(requestItems) > 0 {
+				time.Sleep(backoff)
+
+				backoff *= 2
+				if backoff > batchMaxBackoff {
+					backoff = batchMaxBackoff
+				}
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// batchWriteItems applies the given put and delete requests using BatchWriteItem, in chunks of up to 25 requests,
+// with up to the client's BatchConcurrency chunks in flight at once. Requests that DynamoDB leaves unprocessed
+// are retried with an exponential backoff. The requests are not applied atomically, and a single chunk must
+// not contain two requests for the same key, so callers should pass unique keys.
+func (c Client) batchWriteItems(requests []types.WriteRequest) (err error) {
+	concurrency := c.batchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return firstErr != nil
+	}
+
+	slots := make(chan struct{}, concurrency)
+
+	for start := 0; start < len(requests) && !failed(); start += batchWriteSize {
+		end := start + batchWriteSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		slots <- struct{}{}
+
+		wg.Add(1)
+
+		go func(chunk []types.WriteRequest) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			if err := c.batchWriteChunk(chunk); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}(requests[start:end])
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+func (c Client) batchWriteChunk(chunk []types.WriteRequest) error {
+	requestItems := map[string][]types.WriteRequest{
+		c.tableName: chunk,
+	}
+
+	backoff := batchMinBackoff
+
+	for len(requestItems) > 0 {
+		resp, err := c.ddbClient.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+			RequestItems: requestItems,
+		})
+		if err != nil {
+			return err
+		}
+
+		requestItems = resp.UnprocessedItems
+		if len(requestItems) > 0 {
+			time.Sleep(backoff)
+
+			backoff *= 2
+			if backoff > batchMaxBackoff {
+				backoff = batchMaxBackoff
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c Client) putRequest(item map[string]types.AttributeValue) types.WriteRequest {
+	return types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+}
+
+func (c Client) deleteRequest(key map[string]types.AttributeValue) types.WriteRequest {
+	return types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}
+}
+
+// uniqueStrings removes duplicates from values, keeping the first occurrence of each.
+func uniqueStrings(values []string) (unique []string) {
+	seen := make(map[string]struct{}, len(values))
+
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+
+		seen[value] = struct{}{}
+		unique = append(unique, value)
+	}
+
+	return
+}
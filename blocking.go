@@ -0,0 +1,129 @@
+package redimo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	blockingMinBackoff = 10 * time.Millisecond
+	blockingMaxBackoff = time.Second
+)
+
+// PushNotifier wakes up blocking operations (like BLPOP) running in this process as soon as
+// an element is pushed to one of the keys they are waiting on, instead of letting them sleep
+// until their next poll. Pushes from other processes are still picked up by polling.
+//
+// A single notifier can be shared by any number of clients, attach it with Client.Notifier.
+type PushNotifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+// NewPushNotifier creates an empty notifier, ready to be attached to clients.
+func NewPushNotifier() *PushNotifier {
+	return &PushNotifier{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func notifierKey(c Client, key string) string {
+	return c.tableName + "/" + key
+}
+
+func (n *PushNotifier) subscribe(c Client, keys []string) (wake chan struct{}, cancel func()) {
+	wake = make(chan struct{}, 1)
+
+	if n == nil {
+		return wake, func() {}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, key := range keys {
+		nk := notifierKey(c, key)
+		if n.waiters[nk] == nil {
+			n.waiters[nk] = make(map[chan struct{}]struct{})
+		}
+
+		n.waiters[nk][wake] = struct{}{}
+	}
+
+	return wake, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+
+		for _, key := range keys {
+			nk := notifierKey(c, key)
+			delete(n.waiters[nk], wake)
+
+			if len(n.waiters[nk]) == 0 {
+				delete(n.waiters, nk)
+			}
+		}
+	}
+}
+
+func (n *PushNotifier) notify(c Client, key string) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for wake := range n.waiters[notifierKey(c, key)] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// block calls poll until it reports success, the timeout expires or the context is done. A zero
+// timeout blocks indefinitely, like Redis. Between unsuccessful polls it sleeps with an exponential
+// backoff, which is reset whenever the client's notifier reports a push on one of the keys.
+func (c Client) block(ctx context.Context, timeout time.Duration, keys []string, poll func() (bool, error)) (ok bool, err error) {
+	wake, cancel := c.notifier.subscribe(c, keys)
+	defer cancel()
+
+	var deadline <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	backoff := blockingMinBackoff
+
+	for {
+		ok, err = poll()
+		if err != nil || ok {
+			return ok, err
+		}
+
+		sleep := time.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			sleep.Stop()
+			return false, ctx.Err()
+		case <-deadline:
+			sleep.Stop()
+			return false, nil
+		case <-wake:
+			sleep.Stop()
+
+			backoff = blockingMinBackoff
+		case <-sleep.C:
+			backoff *= 2
+			if backoff > blockingMaxBackoff {
+				backoff = blockingMaxBackoff
+			}
+		}
+	}
+}
@@ -2,7 +2,10 @@ package redimo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,6 +18,11 @@ import (
 
 const earthRadiusMeters = 6372797.560856
 
+const (
+	geoLatKey = "lat"
+	geoLonKey = "lon"
+)
+
 type GLocation struct {
 	Lat float64
 	Lon float64
@@ -24,14 +32,36 @@ func (l GLocation) s2CellID() string {
 	return fmt.Sprintf("%d", s2.CellIDFromLatLng(l.s2LatLng()))
 }
 
+// Geohash returns the Geohash string of the location in the same format as Redis: 11 characters, the first 10 of
+// which encode the location and the last of which is always "0", because Redis only keeps 52 bits of precision.
 func (l GLocation) Geohash() string {
-	return geohash.Encode(l.Lat, l.Lon)
+	return geohash.EncodeWithPrecision(l.Lat, l.Lon, 10) + "0"
 }
 
 func (l GLocation) toAV() types.AttributeValue {
 	return &types.AttributeValueMemberN{Value: l.s2CellID()}
 }
 
+// setItemAttributes stores the location on a geo set item: the S2 cell ID in the numeric sort key for the index,
+// and the exact latitude and longitude, since the cell only approximates them.
+func (l GLocation) setItemAttributes(item map[string]types.AttributeValue, c Client) {
+	item[c.sortKeyNum] = l.toAV()
+	item[geoLatKey] = FloatValue{l.Lat}.ToAV()
+	item[geoLonKey] = FloatValue{l.Lon}.ToAV()
+}
+
+// gLocationFromItem returns the location stored on a geo set item. Items read from the index, and items written
+// before exact coordinates were stored, only have the cell ID, so the center of the cell is used instead, which
+// is accurate to about a centimeter.
+func gLocationFromItem(item map[string]types.AttributeValue, c Client) GLocation {
+	lat, lon := ReturnValue{item[geoLatKey]}, ReturnValue{item[geoLonKey]}
+	if lat.Present() && lon.Present() {
+		return GLocation{Lat: lat.Float(), Lon: lon.Float()}
+	}
+
+	return fromCellIDString(item[c.sortKeyNum].(*types.AttributeValueMemberN).Value)
+}
+
 func (l *GLocation) setCellIDString(cellIDStr string) {
 	cellID, _ := strconv.ParseUint(cellIDStr, 10, 64)
 	s2Cell := s2.CellID(cellID)
@@ -66,6 +96,12 @@ const (
 	Feet       GUnit = 0.3048
 )
 
+// GMember is a location with a payload attached to it, like a status or heading, for GEOADDWITHPAYLOAD.
+type GMember struct {
+	Location GLocation
+	Payload  Value
+}
+
 // GEOADD adds the given members into the key. Members are represented by a map of name to GLocation, which is just a wrapper
 // for latitude and longitude. If a member already exists, its location will be updated. The method only returns the members
 // that were added as part of the operation and did not already exist.
@@ -74,11 +110,52 @@ const (
 //
 // Works similar to https://redis.io/commands/geoadd
 func (c Client) GEOADD(key string, members map[string]GLocation) (newlyAddedMembers map[string]GLocation, err error) {
-	newlyAddedMembers = make(map[string]GLocation)
+	return c.GEOADDWITH(key, members, Flags{})
+}
 
+// GEOADDWITH works like GEOADD, with the following flags supported:
+//
+// IfNotExists, to only add new members and never update existing ones (NX).
+//
+// IfAlreadyExists, to only update existing members and never add new ones (XX).
+//
+// ReturnChanged, to also return the members whose location was changed, instead of only the ones that were added (CH).
+//
+// Cost is O(1) / 1 WCU for each member being added or updated.
+func (c Client) GEOADDWITH(key string, members map[string]GLocation, flags Flags) (newlyAddedMembers map[string]GLocation, err error) {
+	withPayloads := make(map[string]GMember, len(members))
 	for member, location := range members {
+		withPayloads[member] = GMember{Location: location}
+	}
+
+	return c.GEOADDWITHPAYLOAD(key, withPayloads, flags)
+}
+
+// GEOADDWITHPAYLOAD works like GEOADDWITH, but also stores the payload of each member in the same item as its location,
+// so it can be returned by GEOSEARCH (see GSearchOptions.WithPayload) or read with GEOPAYLOAD without another lookup.
+// Members with a nil payload keep the payload they already have.
+//
+// Cost is O(1) / 1 WCU for each member being added or updated.
+func (c Client) GEOADDWITHPAYLOAD(key string, members map[string]GMember, flags Flags) (newlyAddedMembers map[string]GLocation, err error) {
+	newlyAddedMembers = make(map[string]GLocation)
+
+	for member, m := range members {
 		builder := newExpresionBuilder()
-		builder.updateSetAV(c.sortKeyNum, location.toAV())
+		builder.updateSetAV(c.sortKeyNum, m.Location.toAV())
+		builder.updateSetAV(geoLatKey, FloatValue{m.Location.Lat}.ToAV())
+		builder.updateSetAV(geoLonKey, FloatValue{m.Location.Lon}.ToAV())
+
+		if m.Payload != nil {
+			builder.updateSetAV(vk, m.Payload.ToAV())
+		}
+
+		if flags.has(IfNotExists) {
+			builder.addConditionNotExists(c.partitionKey)
+		}
+
+		if flags.has(IfAlreadyExists) {
+			builder.addConditionExists(c.partitionKey)
+		}
 
 		resp, err := c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 			ConditionExpression:       builder.conditionExpression(),
@@ -89,19 +166,43 @@ func (c Client) GEOADD(key string, members map[string]GLocation) (newlyAddedMemb
 			TableName:                 aws.String(c.tableName),
 			UpdateExpression:          builder.updateExpression(),
 		})
+		if conditionFailureError(err) {
+			continue
+		}
 
 		if err != nil {
 			return newlyAddedMembers, err
 		}
 
-		if len(resp.Attributes) < 1 {
-			newlyAddedMembers[member] = location
+		switch {
+		case len(resp.Attributes) < 1:
+			newlyAddedMembers[member] = m.Location
+		case flags.has(ReturnChanged) && gLocationFromItem(resp.Attributes, c) != m.Location:
+			newlyAddedMembers[member] = m.Location
 		}
 	}
 
 	return newlyAddedMembers, nil
 }
 
+// GEOADDBATCH adds the given members like GEOADD, but writes them with batched BatchWriteItem calls,
+// several of them in parallel (see Client.BatchConcurrency). It can't report which members were newly
+// added; use GEOADD when that's needed.
+//
+// Cost is O(N) / 1 WCU for each member, with one request for every 25 members.
+func (c Client) GEOADDBATCH(key string, members map[string]GLocation) (err error) {
+	requests := make([]types.WriteRequest, 0, len(members))
+
+	for member, location := range members {
+		item := keyDef{pk: key, sk: member}.toAV(c)
+		location.setItemAttributes(item, c)
+
+		requests = append(requests, c.putRequest(item))
+	}
+
+	return c.batchWriteItems(requests)
+}
+
 // GEODIST returns the scalar distance between the two members, converted to the given unit. If either of
 // the members or the key is missing, ok will be false. Each GUnit also has convenience methods to convert
 // distances into other units.
@@ -137,7 +238,8 @@ func (c Client) GEOHASH(key string, members ...string) (geohashes map[string]str
 	return
 }
 
-// GEOPOS returns the stored locations for each of the given members, as a map of member to location.
+// GEOPOS returns the stored locations for each of the given members, as a map of member to location. The
+// coordinates are returned exactly as they were added.
 // If a member cannot be found, it will not be present in the returned map.
 //
 // Cost is O(1) / 1 RCU for each member.
@@ -158,7 +260,54 @@ func (c Client) GEOPOS(key string, members ...string) (locations map[string]GLoc
 		}
 
 		if len(resp.Item) > 0 {
-			locations[member] = fromCellIDString(resp.Item[c.sortKeyNum].(*types.AttributeValueMemberN).Value)
+			locations[member] = gLocationFromItem(resp.Item, c)
+		}
+	}
+
+	return
+}
+
+// GEOPAYLOAD returns the payloads stored with GEOADDWITHPAYLOAD for each of the given members. Members that
+// cannot be found, or have no payload, will not be present in the returned map.
+//
+// Cost is O(1) / 1 RCU for each member, with one BatchGetItem call for every 100 members.
+func (c Client) GEOPAYLOAD(key string, members ...string) (payloads map[string]ReturnValue, err error) {
+	payloads = make(map[string]ReturnValue)
+	unique := uniqueStrings(members)
+	keys := make([]map[string]types.AttributeValue, len(unique))
+
+	for i, member := range unique {
+		keys[i] = keyDef{pk: key, sk: member}.toAV(c)
+	}
+
+	items, err := c.batchGetItems(keys)
+
+	for _, item := range items {
+		if payload := (ReturnValue{item[vk]}); payload.Present() {
+			payloads[parseKey(item, c).sk] = payload
+		}
+	}
+
+	return payloads, err
+}
+
+// GEOREM removes the given members from the geo set at key, along with their payloads, and returns the members
+// that were actually removed.
+//
+// Cost is O(1) / 1 WCU for each member.
+func (c Client) GEOREM(key string, members ...string) (removedMembers []string, err error) {
+	for _, member := range members {
+		resp, err := c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			Key:          keyDef{pk: key, sk: member}.toAV(c),
+			ReturnValues: types.ReturnValueAllOld,
+			TableName:    aws.String(c.tableName),
+		})
+		if err != nil {
+			return removedMembers, err
+		}
+
+		if len(resp.Attributes) > 0 {
+			removedMembers = append(removedMembers, member)
 		}
 	}
 
@@ -168,7 +317,7 @@ func (c Client) GEOPOS(key string, members ...string) (locations map[string]GLoc
 // GEORADIUS returns the members (limited to the given count) that are located within the given radius
 // of the given center. Note that the positions are returned in no particular order – if there are more
 // members inside the given radius than the given count, the method *does not* guarantee that the returned
-// locations are the closest.
+// locations are the closest. Use GEOSEARCH to find the nearest members.
 //
 // The GLocation type has convenience methods to calculate the distance between points, this can be used
 // to sort the locations as required.
@@ -231,7 +380,7 @@ func (c Client) GEORADIUS(key string, center GLocation, radius float64, radiusUn
 // GEORADIUSBYMEMBER returns the members (limited to the given count) that are located within the given radius
 // of the given member. Note that the positions are returned in no particular order – if there are more
 // members inside the given radius than the given count, the method *does not* guarantee that the returned
-// locations are the closest.
+// locations are the closest. Use GEOSEARCH to find the nearest members.
 //
 // The GLocation type has convenience methods to calculate the distance between points, this can be used
 // to sort the locations as required.
@@ -248,3 +397,318 @@ func (c Client) GEORADIUSBYMEMBER(key string, member string, radius float64, rad
 
 	return
 }
+
+// gSearchCoverCells is the number of cells the search area is covered with. More cells fit the area more
+// tightly, so fewer members outside of it are read, at the cost of one query per cell.
+const gSearchCoverCells = 16
+
+// gSearchSteps is how many times GEOSEARCH with a count can widen its search area, doubling it every time,
+// before it reads the whole search shape.
+const gSearchSteps = 6
+
+// GSearchOptions describe the center, shape and ordering of a GEOSEARCH.
+//
+// The search is centered on FromMember if it's set (FROMMEMBER), or on FromLocation otherwise (FROMLONLAT).
+// The shape is a circle if Radius is set (BYRADIUS), and a box of Width by Height otherwise (BYBOX), both
+// measured in Unit. Results are sorted by their distance from the center, nearest first, unless Descending
+// is set (DESC). If Count is set, only the first Count members in that order are returned (COUNT); with Any,
+// any Count members inside the shape are returned instead, which is cheaper (ANY). With WithPayload, the
+// payloads stored with GEOADDWITHPAYLOAD are returned as well.
+type GSearchOptions struct {
+	FromMember   string
+	FromLocation GLocation
+	Radius       float64
+	Width        float64
+	Height       float64
+	Unit         GUnit
+	Descending   bool
+	Count        int32
+	Any          bool
+	WithPayload  bool
+}
+
+// GSearchResult is a member found by GEOSEARCH, with its location (WITHCOORD), its distance from the center
+// of the search in the unit of the search (WITHDIST), its Geohash (WITHHASH) and, if requested, its payload.
+// The index only holds the S2 cell of each member, so the location is the center of that cell, accurate to
+// about a centimeter, unless the payload was requested and the exact coordinates were read along with it.
+type GSearchResult struct {
+	Member   string
+	Location GLocation
+	Distance float64
+	Geohash  string
+	Payload  ReturnValue
+}
+
+func (o GSearchOptions) unit() GUnit {
+	if o.Unit == 0 {
+		return Meters
+	}
+
+	return o.Unit
+}
+
+// region returns the search shape around center, scaled down to the given radius in meters if it's smaller
+// than the shape, along with the radius of the circle that bounds the whole shape.
+func (o GSearchOptions) region(center GLocation, within float64) (region s2.Region, contains func(GLocation) bool, bound float64) {
+	if o.Radius > 0 {
+		bound = o.unit().To(Meters, o.Radius)
+	} else {
+		bound = math.Hypot(o.unit().To(Meters, o.Width), o.unit().To(Meters, o.Height)) / 2
+	}
+
+	within = math.Min(within, bound)
+	circle := s2.CapFromCenterAngle(s2.PointFromLatLng(center.s2LatLng()), s1.Angle(within/earthRadiusMeters))
+
+	inCircle := func(l GLocation) bool {
+		return center.DistanceTo(l, Meters) <= within
+	}
+
+	if o.Radius > 0 {
+		return circle, inCircle, bound
+	}
+
+	latSpan := o.unit().To(Meters, o.Height) / earthRadiusMeters
+	lngSpan := o.unit().To(Meters, o.Width) / (earthRadiusMeters * math.Cos(center.s2LatLng().Lat.Radians()))
+	box := s2.RectFromCenterSize(center.s2LatLng(), s2.LatLng{Lat: s1.Angle(latSpan), Lng: s1.Angle(lngSpan)})
+
+	if within < bound {
+		// only the part of the box that's inside the smaller circle.
+		return circle, func(l GLocation) bool { return inCircle(l) && box.ContainsLatLng(l.s2LatLng()) }, bound
+	}
+
+	return box, func(l GLocation) bool { return box.ContainsLatLng(l.s2LatLng()) }, bound
+}
+
+// GEOSEARCH returns the members of the geo set at key that are inside the shape described by the options,
+// sorted by their distance from the center of the search.
+//
+// The shape is covered with S2 cells, and each cell is read with a range query on the index. With a Count
+// (and without Any), the search starts with a small circle around the center and doubles its radius until
+// it has found Count members, or covers the whole shape. Since every member inside a circle is found, the
+// Count nearest members are then known to be the closest – unlike GEORADIUS, which stops at the first Count
+// members it finds.
+//
+// Cost is O(N) where N is the number of members inside the cells covering the area that was searched.
+//
+// Works similar to https://redis.io/commands/geosearch
+func (c Client) GEOSEARCH(key string, options GSearchOptions) (results []GSearchResult, err error) {
+	center := options.FromLocation
+
+	if options.FromMember != "" {
+		locations, err := c.GEOPOS(key, options.FromMember)
+		if err != nil {
+			return results, err
+		}
+
+		location, ok := locations[options.FromMember]
+		if !ok {
+			return results, fmt.Errorf("member %v could not be found", options.FromMember)
+		}
+
+		center = location
+	}
+
+	_, _, bound := options.region(center, math.Inf(+1))
+	within := bound
+
+	if options.Count > 0 && !options.Any && !options.Descending {
+		within = bound / math.Pow(2, gSearchSteps)
+	}
+
+	for {
+		results, err = c.gSearchWithin(key, center, options, within)
+		if err != nil || within >= bound || int32(len(results)) >= options.Count {
+			break
+		}
+
+		within *= 2
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if options.Descending {
+			return results[i].Distance > results[j].Distance
+		}
+
+		return results[i].Distance < results[j].Distance
+	})
+
+	if options.Count > 0 && int32(len(results)) > options.Count {
+		results = results[:options.Count]
+	}
+
+	return results, err
+}
+
+// gSearchWithin returns the members in the search shape that are within the given distance (in meters) of
+// the center. With Any, it stops as soon as it has found Count members.
+func (c Client) gSearchWithin(key string, center GLocation, options GSearchOptions, within float64) (results []GSearchResult, err error) {
+	region, contains, _ := options.region(center, within)
+	coverer := &s2.RegionCoverer{MaxLevel: 30, MaxCells: gSearchCoverCells}
+
+	for _, cellID := range coverer.Covering(region) {
+		err = c.gQueryCell(key, cellID, options.WithPayload, func(member string, location GLocation, payload ReturnValue) bool {
+			if contains(location) {
+				results = append(results, GSearchResult{
+					Member:   member,
+					Location: location,
+					Distance: center.DistanceTo(location, options.unit()),
+					Geohash:  location.Geohash(),
+					Payload:  payload,
+				})
+			}
+
+			return !options.Any || options.Count <= 0 || int32(len(results)) < options.Count
+		})
+
+		if err != nil || (options.Any && options.Count > 0 && int32(len(results)) >= options.Count) {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// gQueryCell calls fn with every member of the geo set at key that's located inside the given cell, until fn returns
+// false. The index only holds the locations, so the payloads are only read from the table if withPayload is set.
+func (c Client) gQueryCell(key string, cellID s2.CellID, withPayload bool,
+	fn func(member string, location GLocation, payload ReturnValue) (more bool)) (err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(c.partitionKey, StringValue{key})
+	builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKeyNum), c.sortKeyNum)
+	builder.values["start"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", cellID.RangeMin())}
+	builder.values["stop"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", cellID.RangeMax())}
+
+	selection := types.SelectAllProjectedAttributes
+	if withPayload {
+		selection = types.SelectAllAttributes
+	}
+
+	hasMoreResults := true
+
+	var cursor map[string]types.AttributeValue
+
+	for hasMoreResults {
+		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+			ConsistentRead:            aws.Bool(c.consistentReads),
+			ExclusiveStartKey:         cursor,
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			IndexName:                 aws.String(c.indexName),
+			KeyConditionExpression:    builder.conditionExpression(),
+			Select:                    selection,
+			TableName:                 aws.String(c.tableName),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range resp.Items {
+			location := gLocationFromItem(item, c)
+
+			if !fn(parseKey(item, c).sk, location, ReturnValue{item[vk]}) {
+				return nil
+			}
+		}
+
+		if len(resp.LastEvaluatedKey) > 0 {
+			cursor = resp.LastEvaluatedKey
+		} else {
+			hasMoreResults = false
+		}
+	}
+
+	return nil
+}
+
+// GEOSEARCHSTORE stores the result of GEOSEARCH in destinationKey, replacing its current members. The members
+// keep their locations, so the destination is a geo set; with storeDistance, their distances from the center
+// are stored as scores instead, making the destination a sorted set (STOREDIST). The swap is atomic, so
+// results too large for a single transaction fail with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/geosearchstore
+func (c Client) GEOSEARCHSTORE(destinationKey string, sourceKey string, options GSearchOptions, storeDistance bool) (results []GSearchResult, err error) {
+	// read the members in full, so their exact coordinates and payloads are copied.
+	options.WithPayload = true
+
+	results, err = c.GEOSEARCH(sourceKey, options)
+	if err != nil {
+		return
+	}
+
+	items := make(map[string]map[string]types.AttributeValue, len(results))
+
+	for _, result := range results {
+		item := keyDef{pk: destinationKey, sk: result.Member}.toAV(c)
+		result.Location.setItemAttributes(item, c)
+
+		if result.Payload.Present() {
+			item[vk] = result.Payload.ToAV()
+		}
+
+		if storeDistance {
+			item[c.sortKeyNum] = zScore{result.Distance}.ToAV()
+		}
+
+		items[result.Member] = item
+	}
+
+	return results, c.replaceItems(destinationKey, items)
+}
+
+// gPolygon builds an S2 polygon from the given vertices. The vertices may be in either order, and the first
+// one may be repeated at the end to close the ring; the polygon is always taken to be the smaller of the two
+// areas the ring divides the sphere into.
+func gPolygon(vertices []GLocation) (polygon *s2.Polygon, err error) {
+	if len(vertices) > 1 && vertices[0] == vertices[len(vertices)-1] {
+		vertices = vertices[:len(vertices)-1]
+	}
+
+	if len(vertices) < 3 {
+		return nil, errors.New("a polygon needs at least three vertices")
+	}
+
+	points := make([]s2.Point, len(vertices))
+	for i, v := range vertices {
+		points[i] = s2.PointFromLatLng(v.s2LatLng())
+	}
+
+	loop := s2.LoopFromPoints(points)
+	loop.Normalize()
+
+	if err = loop.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s2.PolygonFromLoops([]*s2.Loop{loop}), nil
+}
+
+// GEOWITHINPOLYGON returns the members of the geo set at key that are located inside the polygon with the given
+// vertices. The polygon is covered with S2 cells, each of which is read with a range query on the index, and
+// the members inside the cells are then checked against the polygon itself.
+//
+// Cost is O(N) where N is the number of members inside the cells covering the polygon.
+func (c Client) GEOWITHINPOLYGON(key string, vertices []GLocation) (positions map[string]GLocation, err error) {
+	positions = make(map[string]GLocation)
+
+	polygon, err := gPolygon(vertices)
+	if err != nil {
+		return
+	}
+
+	coverer := &s2.RegionCoverer{MaxLevel: 30, MaxCells: gSearchCoverCells}
+
+	for _, cellID := range coverer.Covering(polygon) {
+		err = c.gQueryCell(key, cellID, false, func(member string, location GLocation, _ ReturnValue) bool {
+			if polygon.ContainsPoint(s2.PointFromLatLng(location.s2LatLng())) {
+				positions[member] = location
+			}
+
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -1,6 +1,7 @@
 package redimo
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -13,9 +14,13 @@ func TestPointsAndDistances(t *testing.T) {
 		Lon: 13.361389,
 	}
 	assert.Equal(t, "1376383545825912065", l.s2CellID())
-	assert.Equal(t, "sqc8b49rnyte", l.Geohash())
+	assert.Equal(t, "sqc8b49rny0", l.Geohash())
 	assert.Equal(t, "1376383545825912065", l.toAV().(*types.AttributeValueMemberN).Value)
 
+	// known outputs of GEOHASH in Redis.
+	assert.Equal(t, "sqdtr74hyu0", GLocation{37.502669, 15.087269}.Geohash())
+	assert.Equal(t, "s0000000000", GLocation{0, 0}.Geohash())
+
 	assert.InDelta(t, 32.8084, Meters.To(Feet, 10), 0.01)
 }
 
@@ -41,11 +46,11 @@ func TestGeoBasics(t *testing.T) {
 
 	geohashes, err := c.GEOHASH("Sicily", "Palermo")
 	assert.NoError(t, err)
-	assert.Equal(t, map[string]string{"Palermo": "sqc8b49rnyte"}, geohashes)
+	assert.Equal(t, map[string]string{"Palermo": "sqc8b49rny0"}, geohashes)
 
 	geohashes, err = c.GEOHASH("Sicily", "Palermo", "Catania")
 	assert.NoError(t, err)
-	assert.Equal(t, map[string]string{"Palermo": "sqc8b49rnyte", "Catania": "sqdtr74hyu5n"}, geohashes)
+	assert.Equal(t, map[string]string{"Palermo": "sqc8b49rny0", "Catania": "sqdtr74hyu0"}, geohashes)
 
 	distance, ok, err := c.GEODIST("Sicily", "Palermo", "Catania", Meters)
 	assert.NoError(t, err)
@@ -64,8 +69,15 @@ func TestGeoBasics(t *testing.T) {
 
 	positions, err := c.GEOPOS("Sicily", "Palermo", "Catania")
 	assert.NoError(t, err)
-	assert.InDelta(t, startingMap["Palermo"].Lat, positions["Palermo"].Lat, 0.1)
-	assert.InDelta(t, startingMap["Catania"].Lon, positions["Catania"].Lon, 0.1)
+	assert.Equal(t, startingMap, positions)
+
+	// exact coordinates survive a round trip through GEOSEARCHSTORE as well.
+	_, err = c.GEOSEARCHSTORE("Palermo area", "Sicily", GSearchOptions{FromMember: "Palermo", Radius: 10, Unit: Kilometers}, false)
+	assert.NoError(t, err)
+
+	positions, err = c.GEOPOS("Palermo area", "Palermo")
+	assert.NoError(t, err)
+	assert.Equal(t, startingMap["Palermo"], positions["Palermo"])
 }
 
 func TestGeoRadius(t *testing.T) {
@@ -91,3 +103,153 @@ func TestGeoRadius(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, locations, locations2)
 }
+
+func TestGeoSearch(t *testing.T) {
+	c := newClient(t)
+	_, err := c.GEOADD("india", map[string]GLocation{
+		"chennai":    {13.09, 80.28},
+		"vellore":    {12.9204, 79.15},
+		"pondy":      {11.935, 79.83},
+		"bangalore":  {12.97, 77.56},
+		"coimbatore": {11, 76.95},
+		"madurai":    {9.939093, 78.121719},
+	})
+	assert.NoError(t, err)
+
+	results, err := c.GEOSEARCH("india", GSearchOptions{FromMember: "chennai", Radius: 1000, Unit: Kilometers, Count: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chennai", "vellore", "pondy"}, gSearchMembers(results))
+	assert.InDelta(t, 0, results[0].Distance, 0.1)
+	assert.InDelta(t, 13.09, results[0].Location.Lat, 0.1)
+	assert.Equal(t, results[0].Location.Geohash(), results[0].Geohash)
+	assert.True(t, results[1].Distance < results[2].Distance)
+
+	results, err = c.GEOSEARCH("india", GSearchOptions{FromLocation: GLocation{13.09, 80.28}, Radius: 180, Unit: Kilometers})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"chennai", "vellore", "pondy"}, gSearchMembers(results))
+
+	results, err = c.GEOSEARCH("india", GSearchOptions{FromMember: "chennai", Radius: 350, Unit: Kilometers, Descending: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bangalore", "pondy", "vellore", "chennai"}, gSearchMembers(results))
+
+	results, err = c.GEOSEARCH("india", GSearchOptions{FromMember: "chennai", Radius: 1000, Unit: Kilometers, Count: 2, Any: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+
+	results, err = c.GEOSEARCH("india", GSearchOptions{FromLocation: GLocation{12.2, 79}, Width: 400, Height: 300, Unit: Kilometers})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"chennai", "vellore", "pondy", "bangalore"}, gSearchMembers(results))
+
+	_, err = c.GEOSEARCH("india", GSearchOptions{FromMember: "delhi", Radius: 10})
+	assert.Error(t, err)
+
+	results, err = c.GEOSEARCHSTORE("nearby", "india", GSearchOptions{FromMember: "chennai", Radius: 180, Unit: Kilometers}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(results))
+
+	positions, err := c.GEOPOS("nearby", "vellore")
+	assert.NoError(t, err)
+	assert.InDelta(t, 79.15, positions["vellore"].Lon, 0.1)
+
+	_, err = c.GEOSEARCHSTORE("distances", "india", GSearchOptions{FromMember: "chennai", Radius: 180, Unit: Kilometers}, true)
+	assert.NoError(t, err)
+
+	distance, ok, err := c.ZSCORE("distances", "vellore")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, results[1].Distance, distance, 0.001)
+}
+
+func TestGeoPolygon(t *testing.T) {
+	c := newClient(t)
+	_, err := c.GEOADD("india", map[string]GLocation{
+		"chennai":    {13.09, 80.28},
+		"vellore":    {12.9204, 79.15},
+		"pondy":      {11.935, 79.83},
+		"bangalore":  {12.97, 77.56},
+		"coimbatore": {11, 76.95},
+		"madurai":    {9.939093, 78.121719},
+	})
+	assert.NoError(t, err)
+
+	positions, err := c.GEOWITHINPOLYGON("india", []GLocation{{13.5, 78.5}, {13.5, 81}, {11.5, 81}, {11.5, 78.5}})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(positions))
+	assert.InDelta(t, 80.28, positions["chennai"].Lon, 0.1)
+	assert.Contains(t, positions, "vellore")
+	assert.Contains(t, positions, "pondy")
+
+	// the same area, in the other direction and closed.
+	reversed, err := c.GEOWITHINPOLYGON("india", []GLocation{{13.5, 78.5}, {11.5, 78.5}, {11.5, 81}, {13.5, 81}, {13.5, 78.5}})
+	assert.NoError(t, err)
+	assert.Equal(t, positions, reversed)
+
+	positions, err = c.GEOWITHINPOLYGON("india", []GLocation{{13.5, 76}, {13.5, 78}, {8, 76}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bangalore", "coimbatore"}, readGeoMembers(positions))
+
+	_, err = c.GEOWITHINPOLYGON("india", []GLocation{{13.5, 76}, {13.5, 78}})
+	assert.Error(t, err)
+}
+
+func TestGeoFlagsAndPayloads(t *testing.T) {
+	c := newClient(t)
+
+	added, err := c.GEOADDWITH("fleet", map[string]GLocation{"van": {13.09, 80.28}}, Flags{IfAlreadyExists})
+	assert.NoError(t, err)
+	assert.Empty(t, added)
+
+	added, err = c.GEOADDWITHPAYLOAD("fleet", map[string]GMember{
+		"van":   {Location: GLocation{13.09, 80.28}, Payload: StringValue{"idle"}},
+		"truck": {Location: GLocation{12.9204, 79.15}, Payload: StringValue{"busy"}},
+	}, Flags{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(added))
+
+	added, err = c.GEOADDWITH("fleet", map[string]GLocation{"van": {11.935, 79.83}, "bike": {13.08, 80.27}}, Flags{IfNotExists})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bike"}, readGeoMembers(added))
+
+	added, err = c.GEOADDWITH("fleet", map[string]GLocation{"van": {11.935, 79.83}, "truck": {12.9204, 79.15}}, Flags{ReturnChanged})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"van"}, readGeoMembers(added))
+
+	payloads, err := c.GEOPAYLOAD("fleet", "van", "truck", "bike", "nope")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(payloads))
+	assert.Equal(t, "idle", payloads["van"].String())
+	assert.Equal(t, "busy", payloads["truck"].String())
+
+	results, err := c.GEOSEARCH("fleet", GSearchOptions{FromLocation: GLocation{13.09, 80.28}, Radius: 200, Unit: Kilometers, WithPayload: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bike", "truck", "van"}, gSearchMembers(results))
+	assert.True(t, results[0].Payload.Empty())
+	assert.Equal(t, "busy", results[1].Payload.String())
+	assert.Equal(t, "idle", results[2].Payload.String())
+
+	removed, err := c.GEOREM("fleet", "van", "nope")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"van"}, removed)
+
+	positions, err := c.GEOPOS("fleet", "van", "truck")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"truck"}, readGeoMembers(positions))
+}
+
+func readGeoMembers(positions map[string]GLocation) (members []string) {
+	for member := range positions {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+
+	return
+}
+
+func gSearchMembers(results []GSearchResult) (members []string) {
+	for _, r := range results {
+		members = append(members, r.Member)
+	}
+
+	return
+}
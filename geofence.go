@@ -0,0 +1,328 @@
+package redimo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/golang/geo/s2"
+)
+
+// Geofence tracks members moving in and out of a set of polygon zones, like delivery areas.
+//
+// The zones are stored as JSON in a hash named after the geofence, with the zone names as fields, so they
+// can be listed with the hash commands as well; they must be added and removed with AddZone and RemoveZone
+// though, which also maintain the index used to find the zones containing a location. That index holds the
+// S2 cells covering every zone in the numeric sort key, so a lookup only reads the zones whose covering
+// contains the location, and tests the location against their polygons. The zones each member was last seen
+// in are kept in a companion hash, and Update compares them with the zones containing the member's new position.
+//
+// Updates for the same member should not run concurrently; if they do, an entry or exit may be reported twice.
+type Geofence struct {
+	c    Client
+	name string
+}
+
+// The zone coverings only use cells from every third level between gfMinLevel and gfMaxLevel, so a lookup
+// reads one cell per level, the ancestor of the location at that level.
+const (
+	gfMinLevel = 4
+	gfMaxLevel = 16
+	gfLevelMod = 3
+	gfMaxCells = 16
+)
+
+// NewGeofence creates a geofence whose zones are stored in the hash at the given key.
+func NewGeofence(c Client, name string) Geofence {
+	return Geofence{c: c, name: name}
+}
+
+func (g Geofence) membersKey() string {
+	return fmt.Sprintf("_redimo/geofence/%v/members", g.name)
+}
+
+func (g Geofence) cellsKey() string {
+	return fmt.Sprintf("_redimo/geofence/%v/cells", g.name)
+}
+
+// cellKey returns the key of the index item recording that cell is part of the covering of zone. The cell
+// comes first in the sort key since it can't contain a slash, unlike the zone name.
+func (g Geofence) cellKey(zone string, cell s2.CellID) keyDef {
+	return keyDef{pk: g.cellsKey(), sk: fmt.Sprintf("%d/%v", cell, zone)}
+}
+
+func (g Geofence) cellItem(zone string, cell s2.CellID) map[string]types.AttributeValue {
+	item := g.cellKey(zone, cell).toAV(g.c)
+	item[g.c.sortKeyNum] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", cell)}
+
+	return item
+}
+
+// gfCovering returns the cells covering polygon, at the levels the lookups read.
+func gfCovering(polygon *s2.Polygon) s2.CellUnion {
+	coverer := &s2.RegionCoverer{MinLevel: gfMinLevel, MaxLevel: gfMaxLevel, LevelMod: gfLevelMod, MaxCells: gfMaxCells}
+	return coverer.Covering(polygon)
+}
+
+// gfZonePolygon decodes the vertices of a stored zone into its polygon.
+func gfZonePolygon(encoded ReturnValue) (polygon *s2.Polygon, err error) {
+	var vertices []GLocation
+	if err = json.Unmarshal([]byte(encoded.String()), &vertices); err != nil {
+		return nil, err
+	}
+
+	return gPolygon(vertices)
+}
+
+// AddZone adds a zone with the given polygon vertices, replacing any zone with the same name. See GEOWITHINPOLYGON
+// for how the vertices are interpreted.
+//
+// The new covering is indexed before the zone is stored, and the cells of the replaced covering are only removed
+// after, so lookups never miss the zone; stale cells only cause an extra polygon test.
+//
+// Cost is O(C) where C is the number of cells covering the old and new polygons.
+func (g Geofence) AddZone(zone string, vertices []GLocation) (err error) {
+	polygon, err := gPolygon(vertices)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(vertices)
+	if err != nil {
+		return err
+	}
+
+	previous, err := g.c.HGET(g.name, zone)
+	if err != nil {
+		return err
+	}
+
+	covering := gfCovering(polygon)
+
+	puts := make([]types.WriteRequest, len(covering))
+	for i, cell := range covering {
+		puts[i] = g.c.putRequest(g.cellItem(zone, cell))
+	}
+
+	if err = g.c.batchWriteItems(puts); err != nil {
+		return err
+	}
+
+	if _, err = g.c.HSET(g.name, zone, StringValue{string(encoded)}); err != nil {
+		return err
+	}
+
+	return g.removeCells(zone, previous, covering)
+}
+
+// RemoveZone removes the zone with the given name. Members that were inside it are not notified.
+//
+// Cost is O(C) where C is the number of cells covering the polygon.
+func (g Geofence) RemoveZone(zone string) (ok bool, err error) {
+	previous, err := g.c.HGET(g.name, zone)
+	if err != nil || previous.Empty() {
+		return false, err
+	}
+
+	removed, err := g.c.HDEL(g.name, zone)
+	if err != nil {
+		return false, err
+	}
+
+	return len(removed) > 0, g.removeCells(zone, previous, nil)
+}
+
+// removeCells removes the cells covering the stored polygon of zone from the index, except those in keep.
+func (g Geofence) removeCells(zone string, stored ReturnValue, keep s2.CellUnion) (err error) {
+	if stored.Empty() {
+		return nil
+	}
+
+	polygon, err := gfZonePolygon(stored)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[s2.CellID]struct{}, len(keep))
+	for _, cell := range keep {
+		kept[cell] = struct{}{}
+	}
+
+	var deletes []types.WriteRequest
+
+	for _, cell := range gfCovering(polygon) {
+		if _, ok := kept[cell]; !ok {
+			deletes = append(deletes, g.c.deleteRequest(g.cellKey(zone, cell).toAV(g.c)))
+		}
+	}
+
+	return g.c.batchWriteItems(deletes)
+}
+
+// Zones returns the names of the zones that contain the given location, in lexicographic order.
+//
+// The location's ancestor cell at each covering level is looked up in the index, and only the zones found
+// there are read and tested against the location.
+//
+// Cost is O(Z) where Z is the number of zones whose covering contains the location.
+func (g Geofence) Zones(location GLocation) (zones []string, err error) {
+	leaf := s2.CellIDFromLatLng(location.s2LatLng())
+
+	var candidates []string
+
+	for level := gfMinLevel; level <= gfMaxLevel; level += gfLevelMod {
+		found, err := g.cellZones(leaf.Parent(level))
+		if err != nil {
+			return zones, err
+		}
+
+		candidates = append(candidates, found...)
+	}
+
+	stored, err := g.c.HMGET(g.name, uniqueStrings(candidates)...)
+	if err != nil {
+		return
+	}
+
+	point := s2.PointFromLatLng(location.s2LatLng())
+
+	for zone, encoded := range stored {
+		// the zone was removed, but its cells were not yet.
+		if encoded.Empty() {
+			continue
+		}
+
+		polygon, err := gfZonePolygon(encoded)
+		if err != nil {
+			return zones, err
+		}
+
+		if polygon.ContainsPoint(point) {
+			zones = append(zones, zone)
+		}
+	}
+
+	sort.Strings(zones)
+
+	return zones, nil
+}
+
+// cellZones returns the zones whose covering includes the given cell.
+func (g Geofence) cellZones(cell s2.CellID) (zones []string, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(g.c.partitionKey, StringValue{g.cellsKey()})
+	builder.condition(fmt.Sprintf("#%v = :cell", g.c.sortKeyNum), g.c.sortKeyNum)
+	builder.values["cell"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", cell)}
+
+	hasMoreResults := true
+
+	var cursor map[string]types.AttributeValue
+
+	for hasMoreResults {
+		resp, err := g.c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+			ConsistentRead:            aws.Bool(g.c.consistentReads),
+			ExclusiveStartKey:         cursor,
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			IndexName:                 aws.String(g.c.indexName),
+			KeyConditionExpression:    builder.conditionExpression(),
+			Select:                    types.SelectAllProjectedAttributes,
+			TableName:                 aws.String(g.c.tableName),
+		})
+		if err != nil {
+			return zones, err
+		}
+
+		for _, item := range resp.Items {
+			parts := strings.SplitN(parseKey(item, g.c).sk, "/", 2)
+			if len(parts) == 2 {
+				zones = append(zones, parts[1])
+			}
+		}
+
+		if len(resp.LastEvaluatedKey) > 0 {
+			cursor = resp.LastEvaluatedKey
+		} else {
+			hasMoreResults = false
+		}
+	}
+
+	return zones, nil
+}
+
+// Update records the new position of member, and reports the zones it entered and left since its previous
+// update, in lexicographic order. The first update of a member reports every zone it's in as entered.
+//
+// Cost is the cost of Zones, plus 1 RCU and 1 WCU for the member.
+func (g Geofence) Update(member string, location GLocation) (entered []string, left []string, err error) {
+	zones, err := g.Zones(location)
+	if err != nil {
+		return
+	}
+
+	previous, err := g.c.HGET(g.membersKey(), member)
+	if err != nil {
+		return
+	}
+
+	var before []string
+
+	if !previous.Empty() {
+		if err = json.Unmarshal([]byte(previous.String()), &before); err != nil {
+			return
+		}
+	}
+
+	entered, left = gDiffZones(before, zones)
+
+	if len(entered) == 0 && len(left) == 0 && !previous.Empty() {
+		return
+	}
+
+	encoded, err := json.Marshal(zones)
+	if err != nil {
+		return
+	}
+
+	_, err = g.c.HSET(g.membersKey(), member, StringValue{string(encoded)})
+
+	return entered, left, err
+}
+
+// Forget removes the recorded zones of member, so its next update reports every zone it's in as entered.
+//
+// Cost is O(1) / 1 WCU.
+func (g Geofence) Forget(member string) (err error) {
+	_, err = g.c.HDEL(g.membersKey(), member)
+	return err
+}
+
+// gDiffZones returns the zones in after but not before, and the zones in before but not after.
+func gDiffZones(before, after []string) (added []string, removed []string) {
+	inBefore := make(map[string]struct{}, len(before))
+	for _, zone := range before {
+		inBefore[zone] = struct{}{}
+	}
+
+	inAfter := make(map[string]struct{}, len(after))
+	for _, zone := range after {
+		inAfter[zone] = struct{}{}
+
+		if _, ok := inBefore[zone]; !ok {
+			added = append(added, zone)
+		}
+	}
+
+	for _, zone := range before {
+		if _, ok := inAfter[zone]; !ok {
+			removed = append(removed, zone)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package redimo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeofence(t *testing.T) {
+	c := newClient(t)
+	g := NewGeofence(c, "zones")
+
+	assert.NoError(t, g.AddZone("north", []GLocation{{14, 79}, {14, 81}, {12.5, 81}, {12.5, 79}}))
+	assert.NoError(t, g.AddZone("coast", []GLocation{{14, 80}, {14, 81}, {11, 81}, {11, 80}}))
+	assert.Error(t, g.AddZone("broken", []GLocation{{14, 80}, {14, 81}}))
+
+	zones, err := g.Zones(GLocation{13.09, 80.28})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"coast", "north"}, zones)
+
+	zones, err = g.Zones(GLocation{28.61, 77.2})
+	assert.NoError(t, err)
+	assert.Empty(t, zones)
+
+	entered, left, err := g.Update("van", GLocation{12.9204, 79.15})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"north"}, entered)
+	assert.Empty(t, left)
+
+	entered, left, err = g.Update("van", GLocation{13.09, 80.28})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"coast"}, entered)
+	assert.Empty(t, left)
+
+	entered, left, err = g.Update("van", GLocation{13.1, 80.29})
+	assert.NoError(t, err)
+	assert.Empty(t, entered)
+	assert.Empty(t, left)
+
+	entered, left, err = g.Update("van", GLocation{11.935, 80.5})
+	assert.NoError(t, err)
+	assert.Empty(t, entered)
+	assert.Equal(t, []string{"north"}, left)
+
+	ok, err := g.RemoveZone("coast")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	cells, err := c.listSortKeys(g.cellsKey())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cells)
+
+	for _, cell := range cells {
+		assert.True(t, strings.HasSuffix(cell, "/north"))
+	}
+
+	entered, left, err = g.Update("van", GLocation{11.935, 80.5})
+	assert.NoError(t, err)
+	assert.Empty(t, entered)
+	assert.Equal(t, []string{"coast"}, left)
+
+	assert.NoError(t, g.Forget("van"))
+
+	entered, _, err = g.Update("van", GLocation{13.09, 80.28})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"north"}, entered)
+}
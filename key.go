@@ -9,14 +9,31 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// DEL deletes the key, along with the data some types keep for it in other partitions, and returns the
+// fields (sort keys) that were deleted from the key itself.
 func (c Client) DEL(key string) (deletedFields []string, err error) {
-	fields, err := c.listSortKeys(key)
+	deletedFields, err = c.delPartition(key)
 	if err != nil {
-		return deletedFields, err
+		return
+	}
+
+	// A list keeps track of the holes inside it in a separate partition, which a new list under the same
+	// key must not inherit.
+	if _, err = c.delPartition(listHolesKey(key)); err != nil {
+		return
 	}
 
-	if len(fields) == 0 {
-		return deletedFields, nil
+	// A stream keeps its length, its last XID and its consumer groups outside of its partition as well.
+	err = c.xDestroy(key)
+
+	return
+}
+
+// delPartition deletes every item of the partition at key, and returns their sort keys.
+func (c Client) delPartition(key string) (deletedFields []string, err error) {
+	fields, err := c.listSortKeys(key)
+	if err != nil {
+		return deletedFields, err
 	}
 
 	// ✅ 使用 BatchWriteItem 批量删除（最多 25 项/批）
@@ -0,0 +1,393 @@
+package redimo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LeaderboardPeriod is a time window a Leaderboard keeps scores for.
+type LeaderboardPeriod string
+
+const (
+	Daily   LeaderboardPeriod = "daily"
+	Weekly  LeaderboardPeriod = "weekly"
+	AllTime LeaderboardPeriod = "all-time"
+)
+
+// leaderboardTieSpan is the span of time the tie-break covers, starting at leaderboardTieEpoch.
+const leaderboardTieSpan = 100 * 365 * 24 * time.Hour
+
+var leaderboardTieEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Leaderboard keeps the best score of each member for a number of periods (daily, weekly and all-time),
+// built only on ZADD, ZREVRANGE, ZREVRANK and hashes.
+//
+// Each period is a sorted set whose key includes the day or week it covers, so boards rotate by themselves
+// as time passes; old boards are deleted by Expire once they are past the retention. Scores must be whole
+// numbers: the fractional part of the stored score holds a tie-break, so that between members with the
+// same score the one that reached it first ranks higher. The tie-break is as precise as the magnitude of the
+// score allows, about a second for scores around a million, so scores should stay well below 2^40.
+//
+// Member metadata (like display names) is kept as JSON in a companion hash, and the top of each board can be
+// cached in another hash to serve the most common query with a single read.
+type Leaderboard struct {
+	c         Client
+	name      string
+	periods   []LeaderboardPeriod
+	retention int
+	cacheSize int32
+	cacheTTL  time.Duration
+	at        time.Time
+}
+
+// LeaderboardEntry is a member of a leaderboard with its score and zero based rank, highest score first.
+type LeaderboardEntry struct {
+	Member   string            `json:"member"`
+	Score    int64             `json:"score"`
+	Rank     int32             `json:"rank"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type leaderboardCache struct {
+	Expires int64              `json:"expires"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// NewLeaderboard creates a leaderboard with daily, weekly and all-time periods, keeping the previous day and
+// week around until Expire removes them. The top-N cache is disabled by default.
+func NewLeaderboard(c Client, name string) Leaderboard {
+	return Leaderboard{
+		c:         c,
+		name:      name,
+		periods:   []LeaderboardPeriod{Daily, Weekly, AllTime},
+		retention: 1,
+	}
+}
+
+// Periods sets the periods scores are submitted to.
+func (lb Leaderboard) Periods(periods ...LeaderboardPeriod) Leaderboard {
+	lb.periods = periods
+	return lb
+}
+
+// Retention sets how many past days (for the daily board) and weeks (for the weekly board) are kept before Expire deletes them.
+func (lb Leaderboard) Retention(periods int) Leaderboard {
+	lb.retention = periods
+	return lb
+}
+
+// Cache enables the top-N cache: Top requests for up to size entries are served from a cached copy of the top of
+// the board, which is refreshed when it's older than ttl. Scores submitted in between show up after the refresh.
+func (lb Leaderboard) Cache(size int32, ttl time.Duration) Leaderboard {
+	lb.cacheSize, lb.cacheTTL = size, ttl
+	return lb
+}
+
+// At returns a view of the leaderboard at the given time, to read (or submit to) the boards of a past period.
+func (lb Leaderboard) At(t time.Time) Leaderboard {
+	lb.at = t
+	return lb
+}
+
+func (lb Leaderboard) now() time.Time {
+	if lb.at.IsZero() {
+		return time.Now().UTC()
+	}
+
+	return lb.at.UTC()
+}
+
+// PeriodKey returns the key of the sorted set that holds the given period's board at time t.
+func (lb Leaderboard) PeriodKey(period LeaderboardPeriod, t time.Time) string {
+	t = t.UTC()
+
+	switch period {
+	case Daily:
+		return fmt.Sprintf("%v/%v/%v", lb.name, period, t.Format("2006-01-02"))
+	case Weekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%v/%v/%04d-W%02d", lb.name, period, year, week)
+	default:
+		return fmt.Sprintf("%v/%v", lb.name, period)
+	}
+}
+
+// periodEnd returns when the period that contains t ends, and false for periods that never end.
+func (lb Leaderboard) periodEnd(period LeaderboardPeriod, t time.Time) (end time.Time, ok bool) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case Daily:
+		return day.AddDate(0, 0, 1), true
+	case Weekly:
+		// ISO weeks start on Monday.
+		return day.AddDate(0, 0, 7-(int(t.Weekday())+6)%7), true
+	default:
+		return end, false
+	}
+}
+
+func (lb Leaderboard) membersKey() string {
+	return fmt.Sprintf("_redimo/leaderboard/%v/members", lb.name)
+}
+
+func (lb Leaderboard) boardsKey() string {
+	return fmt.Sprintf("_redimo/leaderboard/%v/boards", lb.name)
+}
+
+func (lb Leaderboard) cacheKey() string {
+	return fmt.Sprintf("_redimo/leaderboard/%v/cache", lb.name)
+}
+
+// leaderboardScore encodes the score along with the time it was reached, so that earlier submissions of the same
+// score sort higher.
+func leaderboardScore(score int64, t time.Time) float64 {
+	elapsed := float64(t.Sub(leaderboardTieEpoch)) / float64(leaderboardTieSpan)
+	tieBreak := math.Min(math.Max(1-elapsed, 0), 1)
+
+	// keep the tie-break strictly below 1, so it never spills over into the score itself.
+	return float64(score) + math.Min(tieBreak, 1-1e-9)
+}
+
+func leaderboardDecode(score float64) int64 {
+	return int64(math.Floor(score))
+}
+
+// Submit records a score for member on every period of the leaderboard, keeping the member's best score. If
+// metadata is not nil, it replaces the member's metadata.
+//
+// Cost is O(1) / 1 WCU per period, plus 1 WCU for the metadata and 1 WCU per rotating period to register the board for expiry.
+func (lb Leaderboard) Submit(member string, score int64, metadata map[string]string) (err error) {
+	now := lb.now()
+	encoded := leaderboardScore(score, now)
+
+	for _, period := range lb.periods {
+		key := lb.PeriodKey(period, now)
+
+		_, err = lb.c.ZADD(key, map[string]float64{member: encoded}, Flags{IfGreaterThan})
+		if err != nil {
+			return err
+		}
+
+		if end, ok := lb.periodEnd(period, now); ok {
+			expires := end.AddDate(0, 0, lb.retention)
+			if period == Weekly {
+				expires = end.AddDate(0, 0, 7*lb.retention)
+			}
+
+			if _, err = lb.c.HSETNX(lb.boardsKey(), key, IntValue{expires.Unix()}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if metadata != nil {
+		encodedMetadata, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+
+		if _, err = lb.c.HSET(lb.membersKey(), member, StringValue{string(encodedMetadata)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Top returns the first count entries of the current board of the period, with their metadata.
+//
+// Cost is O(count) reads, or a single read when served from the cache.
+func (lb Leaderboard) Top(period LeaderboardPeriod, count int32) (entries []LeaderboardEntry, err error) {
+	if count <= 0 {
+		return
+	}
+
+	key := lb.PeriodKey(period, lb.now())
+
+	if lb.cacheSize >= count {
+		entries, ok, err := lb.cachedTop(key)
+		if err != nil || ok {
+			if int32(len(entries)) > count {
+				entries = entries[:count]
+			}
+
+			return entries, err
+		}
+
+		entries, err = lb.page(key, 0, lb.cacheSize-1)
+		if err != nil {
+			return entries, err
+		}
+
+		if err = lb.storeTop(key, entries); err != nil {
+			return entries, err
+		}
+
+		if int32(len(entries)) > count {
+			entries = entries[:count]
+		}
+
+		return entries, nil
+	}
+
+	return lb.page(key, 0, count-1)
+}
+
+// AroundMe returns the entries ranked up to n places above and below member on the current board of the
+// period, including the member itself. If the member isn't on the board, ok will be false.
+//
+// Cost is O(n) reads, plus the cost of ZREVRANK.
+func (lb Leaderboard) AroundMe(period LeaderboardPeriod, member string, n int32) (entries []LeaderboardEntry, ok bool, err error) {
+	key := lb.PeriodKey(period, lb.now())
+
+	rank, ok, err := lb.c.ZREVRANK(key, member)
+	if err != nil || !ok {
+		return
+	}
+
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+
+	entries, err = lb.page(key, start, rank+n)
+
+	return entries, err == nil, err
+}
+
+// Rank returns the zero based rank (highest score first) and score of member on the current board of the
+// period. If the member isn't on the board, ok will be false.
+func (lb Leaderboard) Rank(period LeaderboardPeriod, member string) (entry LeaderboardEntry, ok bool, err error) {
+	entries, ok, err := lb.AroundMe(period, member, 0)
+	if err != nil || !ok || len(entries) == 0 {
+		return entry, false, err
+	}
+
+	return entries[0], true, nil
+}
+
+// Metadata returns the metadata stored for member.
+func (lb Leaderboard) Metadata(member string) (metadata map[string]string, err error) {
+	val, err := lb.c.HGET(lb.membersKey(), member)
+	if err != nil || val.Empty() {
+		return
+	}
+
+	err = json.Unmarshal([]byte(val.String()), &metadata)
+
+	return
+}
+
+// Expire deletes the boards of past periods that are beyond the retention, along with their cached tops, and
+// returns their keys.
+//
+// Cost is O(N) where N is the size of the deleted boards.
+func (lb Leaderboard) Expire() (expired []string, err error) {
+	boards, err := lb.c.HGETALL(lb.boardsKey())
+	if err != nil {
+		return
+	}
+
+	now := lb.now().Unix()
+
+	for key, expires := range boards {
+		if expires.Int() > now {
+			continue
+		}
+
+		if _, err = lb.c.DEL(key); err != nil {
+			return expired, err
+		}
+
+		// DEL doesn't go through the rank index, so drop the board's index along with its members.
+		if lb.c.rankIndex != nil {
+			if _, err = lb.c.delPartition(zRankKey(key)); err != nil {
+				return expired, err
+			}
+		}
+
+		if _, err = lb.c.HDEL(lb.cacheKey(), key); err != nil {
+			return expired, err
+		}
+
+		if _, err = lb.c.HDEL(lb.boardsKey(), key); err != nil {
+			return expired, err
+		}
+
+		expired = append(expired, key)
+	}
+
+	return expired, nil
+}
+
+// page reads the entries between the start and stop ranks of the board at key, with their metadata.
+func (lb Leaderboard) page(key string, start, stop int32) (entries []LeaderboardEntry, err error) {
+	members, err := lb.c.ZREVRANGEWITHSCORES(key, start, stop)
+	if err != nil || len(members) == 0 {
+		return
+	}
+
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+	}
+
+	metadata, err := lb.c.HMGET(lb.membersKey(), names...)
+	if err != nil {
+		return
+	}
+
+	for i, m := range members {
+		entry := LeaderboardEntry{
+			Member: m.Member,
+			Score:  leaderboardDecode(m.Score),
+			Rank:   start + int32(i),
+		}
+
+		if encoded := metadata[m.Member]; !encoded.Empty() {
+			if err = json.Unmarshal([]byte(encoded.String()), &entry.Metadata); err != nil {
+				return entries, err
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (lb Leaderboard) cachedTop(key string) (entries []LeaderboardEntry, ok bool, err error) {
+	val, err := lb.c.HGET(lb.cacheKey(), key)
+	if err != nil || val.Empty() {
+		return
+	}
+
+	var cache leaderboardCache
+	if err = json.Unmarshal([]byte(val.String()), &cache); err != nil {
+		return
+	}
+
+	if cache.Expires <= lb.now().UnixNano() {
+		return nil, false, nil
+	}
+
+	return cache.Entries, true, nil
+}
+
+func (lb Leaderboard) storeTop(key string, entries []LeaderboardEntry) (err error) {
+	encoded, err := json.Marshal(leaderboardCache{
+		Expires: lb.now().Add(lb.cacheTTL).UnixNano(),
+		Entries: entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = lb.c.HSET(lb.cacheKey(), key, StringValue{string(encoded)})
+
+	return err
+}
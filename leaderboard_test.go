@@ -0,0 +1,115 @@
+package redimo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderboard(t *testing.T) {
+	c := newClient(t)
+	monday := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	lb := NewLeaderboard(c, "arena").At(monday)
+
+	assert.Equal(t, "arena/daily/2021-03-01", lb.PeriodKey(Daily, monday))
+	assert.Equal(t, "arena/weekly/2021-W09", lb.PeriodKey(Weekly, monday))
+	assert.Equal(t, "arena/all-time", lb.PeriodKey(AllTime, monday))
+
+	assert.NoError(t, lb.Submit("alice", 100, map[string]string{"name": "Alice"}))
+	assert.NoError(t, lb.At(monday.Add(time.Minute)).Submit("bob", 100, map[string]string{"name": "Bob"}))
+	assert.NoError(t, lb.Submit("carol", 300, nil))
+	assert.NoError(t, lb.Submit("dave", 50, nil))
+	assert.NoError(t, lb.Submit("carol", 200, nil))
+
+	top, err := lb.Top(Daily, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []LeaderboardEntry{
+		{Member: "carol", Score: 300, Rank: 0},
+		{Member: "alice", Score: 100, Rank: 1, Metadata: map[string]string{"name": "Alice"}},
+		{Member: "bob", Score: 100, Rank: 2, Metadata: map[string]string{"name": "Bob"}},
+	}, top)
+
+	around, ok, err := lb.AroundMe(Weekly, "alice", 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"carol", "alice", "bob"}, leaderboardMembers(around))
+
+	around, ok, err = lb.AroundMe(AllTime, "dave", 2)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"alice", "bob", "dave"}, leaderboardMembers(around))
+
+	_, ok, err = lb.AroundMe(Daily, "nobody", 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	entry, ok, err := lb.Rank(Daily, "bob")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), entry.Rank)
+	assert.Equal(t, int64(100), entry.Score)
+
+	metadata, err := lb.Metadata("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "Alice"}, metadata)
+
+	tuesday := lb.At(monday.AddDate(0, 0, 1))
+	top, err = tuesday.Top(Daily, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, top)
+
+	top, err = tuesday.Top(Weekly, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"carol"}, leaderboardMembers(top))
+
+	expired, err := tuesday.Expire()
+	assert.NoError(t, err)
+	assert.Empty(t, expired)
+
+	expired, err = lb.At(monday.AddDate(0, 0, 2)).Expire()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"arena/daily/2021-03-01"}, expired)
+
+	count, err := c.ZCARD("arena/daily/2021-03-01")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), count)
+
+	count, err = c.ZCARD("arena/all-time")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), count)
+}
+
+func TestLeaderboardCache(t *testing.T) {
+	c := newClient(t)
+	lb := NewLeaderboard(c, "arena").Periods(AllTime).Cache(2, time.Hour)
+
+	assert.NoError(t, lb.Submit("alice", 10, nil))
+	assert.NoError(t, lb.Submit("bob", 20, nil))
+
+	top, err := lb.Top(AllTime, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "alice"}, leaderboardMembers(top))
+
+	assert.NoError(t, lb.Submit("carol", 30, nil))
+
+	top, err = lb.Top(AllTime, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, leaderboardMembers(top))
+
+	top, err = lb.Top(AllTime, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"carol", "bob", "alice"}, leaderboardMembers(top))
+
+	top, err = lb.Cache(2, 0).Top(AllTime, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"carol", "bob"}, leaderboardMembers(top))
+}
+
+func leaderboardMembers(entries []LeaderboardEntry) (members []string) {
+	for _, e := range entries {
+		members = append(members, e.Member)
+	}
+
+	return
+}
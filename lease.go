@@ -0,0 +1,111 @@
+package redimo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Queue and Scheduler both move the items they hand out to a processing area, a partition where every item
+// holds a lease whose expiry time is stored in the numeric sort key. The helpers below implement the parts
+// of that pattern they share: taking a lease, finding expired leases and giving a lease up.
+
+// takeLease moves an item to the processing area in a single transaction: the source item is deleted if it
+// matches the given condition, and the leased item is put in its place. Any additional actions are included
+// in the same transaction.
+func (c Client) takeLease(from keyDef, condition expressionBuilder, leased map[string]types.AttributeValue,
+	actions ...types.TransactWriteItem) (err error) {
+	_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					ConditionExpression:       condition.conditionExpression(),
+					ExpressionAttributeNames:  condition.expressionAttributeNames(),
+					ExpressionAttributeValues: condition.expressionAttributeValues(),
+					Key:                       from.toAV(c),
+					TableName:                 aws.String(c.tableName),
+				},
+			},
+			{
+				Put: &types.Put{
+					Item:      leased,
+					TableName: aws.String(c.tableName),
+				},
+			},
+		}, actions...),
+	})
+
+	return
+}
+
+// releaseLease deletes the item with the given ID from the processing area at key, if it still holds the
+// lease that expires at leaseExpires, in the same transaction as the given actions. An item that was
+// completed or leased again concurrently is left alone, and the condition failure is returned.
+func (c Client) releaseLease(key string, id string, leaseExpires time.Time, actions ...types.TransactWriteItem) (err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(c.sortKeyNum, IntValue{timeToMillis(leaseExpires)})
+
+	_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					ConditionExpression:       builder.conditionExpression(),
+					ExpressionAttributeNames:  builder.expressionAttributeNames(),
+					ExpressionAttributeValues: builder.expressionAttributeValues(),
+					Key:                       keyDef{pk: key, sk: id}.toAV(c),
+					TableName:                 aws.String(c.tableName),
+				},
+			},
+		}, actions...),
+	})
+
+	return
+}
+
+// expiredLeases calls release for every item in the processing area at key whose lease expired at or before
+// now, in the order of expiry. It stops at the first error.
+func (c Client) expiredLeases(key string, now time.Time, release func(item map[string]types.AttributeValue) error) error {
+	hasMoreResults := true
+
+	var cursor map[string]types.AttributeValue
+
+	for hasMoreResults {
+		builder := newExpresionBuilder()
+		builder.addConditionEquality(c.partitionKey, StringValue{key})
+		builder.condition(fmt.Sprintf("#%v <= :now", c.sortKeyNum), c.sortKeyNum)
+		builder.values["now"] = IntValue{timeToMillis(now)}.ToAV()
+
+		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+			ConsistentRead:            aws.Bool(c.consistentReads),
+			ExclusiveStartKey:         cursor,
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			IndexName:                 aws.String(c.indexName),
+			KeyConditionExpression:    builder.conditionExpression(),
+			Select:                    types.SelectAllAttributes,
+			TableName:                 aws.String(c.tableName),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(resp.LastEvaluatedKey) > 0 {
+			cursor = resp.LastEvaluatedKey
+		} else {
+			hasMoreResults = false
+		}
+
+		for _, item := range resp.Items {
+			if err := release(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -18,6 +20,13 @@ const (
 	ListSKIndexRight = "index_right"
 )
 
+const (
+	// listCompactionHoles is the number of holes after which LREM compacts the list.
+	listCompactionHoles = 256
+
+	listContentionRetries = 5
+)
+
 type LSide string
 
 const (
@@ -40,20 +49,31 @@ func (c Client) LINDEX(key string, index int64) (element ReturnValue, err error)
 	return elements[0], nil
 }
 
+// LLEN returns the number of elements in the list.
+//
+// The length is worked out from the indexes at both ends of the list and the holes recorded inside it. An
+// index that a push reserved but never wrote (because the process crashed in between) isn't known to be a
+// hole until a read like LRANGE or LINDEX passes over it, or LCOMPACT runs, so until then it's counted.
+//
+// Cost is O(1) / a handful of single item queries on the index, plus reading the holes left by LREM (if any).
 func (c Client) LLEN(key string) (length int64, err error) {
-	count, err := c.lLen(key)
-	return int64(count), err
+	layout, err := c.lLayout(key)
+	return layout.length(), err
 }
 
 func (c Client) LPOP(key string) (element ReturnValue, err error) {
-	_, items, err := c.lGeneralRangeWithItems(key, 0, 1, true, c.sortKeyNum)
+	return c.lPop(key, true)
+}
 
-	if err != nil || len(items) == 0 {
+func (c Client) lPop(key string, left bool) (element ReturnValue, err error) {
+	item, err := c.lEndItem(key, left)
+
+	if err != nil || item == nil {
 		return element, err
 	}
 
-	// delete item 0 with condition to prevent concurrent duplicate deletion
-	sk := items[0][c.sortKey].(*types.AttributeValueMemberS).Value
+	// delete the item with condition to prevent concurrent duplicate deletion
+	sk := item[c.sortKey].(*types.AttributeValueMemberS).Value
 
 	result, err := c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		Key:                      keyDef{pk: key, sk: sk}.toAV(c),
@@ -77,7 +97,7 @@ func (c Client) LPOP(key string) (element ReturnValue, err error) {
 	}
 
 	element = ReturnValue{
-		av: items[0][vk],
+		av: item[vk],
 	}
 
 	return
@@ -93,41 +113,6 @@ func (c Client) createRightIndex(key string) (index int64, err error) {
 	return int64(v), err
 }
 
-func (c Client) lLen(key string) (count int32, err error) {
-	hasMoreResults := true
-
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	for hasMoreResults {
-		builder := newExpresionBuilder()
-		builder.addConditionEquality(c.partitionKey, StringValue{key})
-
-		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
-			ConsistentRead:            aws.Bool(c.consistentReads),
-			ExclusiveStartKey:         lastEvaluatedKey,
-			ExpressionAttributeNames:  builder.expressionAttributeNames(),
-			ExpressionAttributeValues: builder.expressionAttributeValues(),
-			KeyConditionExpression:    builder.conditionExpression(),
-			TableName:                 aws.String(c.tableName),
-			Select:                    types.SelectCount,
-		})
-
-		if err != nil {
-			return count, err
-		}
-
-		count += resp.ScannedCount
-
-		if len(resp.LastEvaluatedKey) > 0 {
-			lastEvaluatedKey = resp.LastEvaluatedKey
-		} else {
-			hasMoreResults = false
-		}
-	}
-
-	return
-}
-
 func (c Client) LPUSH(key string, elements ...interface{}) (newLength int64, err error) {
 	return c.lPush(key, true, elements...)
 }
@@ -192,170 +177,382 @@ func (c Client) lPush(key string, left bool, elements ...interface{}) (newLength
 		}
 
 		if err != nil {
-			return length + int64(index), err
+			// the index has been reserved but will never be written, so it's a hole.
+			return length + int64(index), c.lRecordHole(key, e.(StringValue).S, score, err)
 		}
 	}
 
+	c.notifier.notify(c, key)
+
 	return length + int64(len(vElements)), nil
 }
 
+// lPutAction reserves the next index on the given side of the list and returns a transaction action that stores the
+// element at that index, along with any extra attributes. This allows callers to push an element to a list atomically
+// with other changes. If the transaction fails, the caller must record the returned index with lRecordHole.
+func (c Client) lPutAction(key string, left bool, element string, extra map[string]types.AttributeValue) (action types.TransactWriteItem, index int64, err error) {
+	if left {
+		index, err = c.createLeftIndex(key)
+	} else {
+		index, err = c.createRightIndex(key)
+	}
+
+	if err != nil {
+		return action, index, err
+	}
+
+	item := keyDef{pk: key, sk: genSk(element, index)}.toAV(c)
+	item[c.sortKeyNum] = zScore{float64(index)}.ToAV()
+	item[vk] = StringValue{element}.ToAV()
+
+	for k, v := range extra {
+		item[k] = v
+	}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			Item:      item,
+			TableName: aws.String(c.tableName),
+		},
+	}, index, nil
+}
+
 func (c Client) RPUSH(key string, elements ...interface{}) (newLength int64, err error) {
 	return c.lPush(key, false, elements...)
 }
 
-func (c Client) lRange(key string, start int64, end int64, forward bool) (elements []ReturnValue, err error) {
-	llen, err := c.LLEN(key)
+// LPUSHCAPPED works like LPUSH, but keeps at most max elements in the list by evicting elements from the tail
+// in the same transaction as the push. For a list that's only pushed to from the left, these are the oldest
+// elements, so this replaces the common "LPUSH, then LTRIM key 0 max-1" pattern without re-reading the list.
+// If more elements than max are pushed at once, only the last max of them are kept.
+//
+// If the push and the evictions need more than the client's TransactionActions writes, they are split over
+// several transactions, and readers can briefly see the list above its cap.
+//
+// Cost is O(N + M) WCUs, where N is the number of elements pushed and M the number of elements evicted.
+func (c Client) LPUSHCAPPED(key string, max int64, elements ...interface{}) (newLength int64, err error) {
+	return c.lPushCapped(key, true, max, elements...)
+}
+
+// RPUSHCAPPED works like RPUSH, but keeps at most max elements in the list by evicting elements from the head
+// in the same transaction as the push. See LPUSHCAPPED for details.
+func (c Client) RPUSHCAPPED(key string, max int64, elements ...interface{}) (newLength int64, err error) {
+	return c.lPushCapped(key, false, max, elements...)
+}
+
+func (c Client) lPushCapped(key string, left bool, max int64, elements ...interface{}) (newLength int64, err error) {
+	vElements, err := ToValuesE(elements)
 	if err != nil {
-		return elements, err
+		return 0, err
 	}
 
-	if start < 0 {
-		start = llen + start
+	if max < 1 {
+		return 0, errors.New("max must be positive")
 	}
 
-	if end < 0 {
-		end = llen + end
+	// elements past the cap would be evicted by the push itself, so they are never written.
+	if int64(len(vElements)) > max {
+		vElements = vElements[int64(len(vElements))-max:]
 	}
 
-	if start < 0 {
-		start = 0
+	n := int64(len(vElements))
+	if n == 0 {
+		return c.LLEN(key)
 	}
 
-	if end >= llen {
-		end = llen - 1
+	// reserve all the indexes at once.
+	field, delta := ListSKIndexRight, n
+	if left {
+		field, delta = ListSKIndexLeft, -n
 	}
 
-	if start > end || start >= llen {
-		return elements, nil
+	counter, err := c.HINCRBY(listMetaKey(key), field, delta)
+	if err != nil {
+		return 0, err
 	}
 
-	count := end - start + 1
-	return c.lGeneralRange(key, start, count, forward, c.sortKeyNum)
-}
+	values := make([]string, n)
+	indexes := make([]int64, n)
+	puts := make([]types.TransactWriteItem, n)
 
-// offset Ϊ���
-func (c Client) lGeneralRange(key string, offset int64, count int64, forward bool, attribute string) (elements []ReturnValue, err error) {
-	elements = make([]ReturnValue, 0)
-	index := int64(0)
-	remainingCount := count
-	hasMoreResults := true
+	for i, e := range vElements {
+		values[i] = e.(StringValue).S
+		indexes[i] = counter - n + 1 + int64(i)
 
-	var lastKey map[string]types.AttributeValue
+		if left {
+			indexes[i] = counter + n - 1 - int64(i)
+		}
 
-	for hasMoreResults {
-		var queryLimit *int32
-		if remainingCount > 0 {
-			queryLimit = aws.Int32(int32(remainingCount) + int32(offset) - int32(index))
+		item := keyDef{pk: key, sk: genSk(values[i], indexes[i])}.toAV(c)
+		item[c.sortKeyNum] = IntValue{indexes[i]}.ToAV()
+		item[vk] = StringValue{values[i]}.ToAV()
+
+		puts[i] = types.TransactWriteItem{
+			Put: &types.Put{
+				Item:      item,
+				TableName: aws.String(c.tableName),
+			},
 		}
+	}
 
-		builder := newExpresionBuilder()
-		builder.addConditionEquality(c.partitionKey, StringValue{key})
+	written := 0
 
-		var queryIndex *string
-		if attribute == c.sortKeyNum {
-			queryIndex = aws.String(c.indexName)
+	defer func() {
+		if err != nil {
+			for i := written; i < len(puts); i++ {
+				if err = c.lRecordHole(key, values[i], indexes[i], err); errors.Is(err, errListHole) {
+					return
+				}
+			}
 		}
+	}()
 
-		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
-			ConsistentRead:            aws.Bool(c.consistentReads),
-			ExclusiveStartKey:         lastKey,
-			ExpressionAttributeNames:  builder.expressionAttributeNames(),
-			ExpressionAttributeValues: builder.expressionAttributeValues(),
-			IndexName:                 queryIndex,
-			KeyConditionExpression:    builder.conditionExpression(),
-			Limit:                     queryLimit,
-			ScanIndexForward:          aws.Bool(forward),
-			TableName:                 aws.String(c.tableName),
-			Select:                    types.SelectAllAttributes,
-		})
+	for retryCount := 0; retryCount < listContentionRetries; retryCount++ {
+		layout, err := c.lLayout(key)
+		if err != nil {
+			return 0, err
+		}
+
+		length, pending := layout.length(), int64(len(puts)-written)
+		evict := length + pending - max
+
+		var victims []map[string]types.AttributeValue
+
+		if evict > 0 && left {
+			victims, err = c.lRangeItems(key, -evict, -1, true)
+		} else if evict > 0 {
+			victims, err = c.lRangeItems(key, 0, evict-1, true)
+		}
 
 		if err != nil {
-			return elements, err
+			return 0, err
 		}
 
-		for _, item := range resp.Items {
-			if index >= offset {
-				// Read actual value from val field, not from sk
-				elements = append(elements, ReturnValue{
-					av: item[vk],
-				})
-				remainingCount--
+		actions := append([]types.TransactWriteItem{}, puts[written:]...)
+
+		for _, victim := range victims {
+			builder := newExpresionBuilder()
+			builder.addConditionExists(c.partitionKey)
+
+			actions = append(actions, types.TransactWriteItem{
+				Delete: &types.Delete{
+					ConditionExpression:      builder.conditionExpression(),
+					ExpressionAttributeNames: builder.expressionAttributeNames(),
+					Key:                      keyDef{pk: key, sk: victim[c.sortKey].(*types.AttributeValueMemberS).Value}.toAV(c),
+					TableName:                aws.String(c.tableName),
+				},
+			})
+		}
+
+		conflict := false
+
+		for len(actions) > 0 && !conflict {
+			chunk := actions
+			if len(chunk) > c.transactionActions {
+				chunk = chunk[:c.transactionActions]
 			}
-			index++
+
+			_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+				TransactItems: chunk,
+			})
+
+			if conditionFailureError(err) {
+				// one of the evicted elements was removed concurrently, look at the list again.
+				conflict = true
+				continue
+			}
+
+			if err != nil {
+				return 0, err
+			}
+
+			for _, action := range chunk {
+				if action.Put != nil {
+					written++
+				}
+			}
+
+			actions = actions[len(chunk):]
 		}
 
-		if len(resp.LastEvaluatedKey) > 0 && remainingCount > 0 {
-			lastKey = resp.LastEvaluatedKey
-		} else {
-			hasMoreResults = false
+		if !conflict {
+			c.notifier.notify(c, key)
+			return length + pending - int64(len(victims)), nil
 		}
 	}
 
-	return elements, nil
-}
+	err = errors.New("too much contention")
 
-// parseVal is no longer needed - values are stored in val field
-// sk now contains sha256(val)|index for fixed-length keys
+	return 0, err
+}
 
-func (c Client) lGeneralRangeWithItems(key string,
-	offset int64, count int64,
-	forward bool, attribute string) (elements []ReturnValue, items []map[string]types.AttributeValue, err error) {
+func (c Client) lRange(key string, start int64, end int64, forward bool) (elements []ReturnValue, err error) {
+	items, err := c.lRangeItems(key, start, end, forward)
 
-	llen, err := c.LLEN(key)
-	if err != nil {
-		return elements, items, err
+	elements = make([]ReturnValue, 0, len(items))
+	for _, item := range items {
+		elements = append(elements, ReturnValue{item[vk]})
 	}
 
-	start := offset
-	end := offset + count - 1
+	return elements, err
+}
 
-	if start < 0 {
-		start = llen + start
-	}
+// lLayout describes where the elements of a list live on the index. Left pushes use decreasing negative
+// indexes and right pushes use increasing positive indexes, so the elements always form at most two runs
+// of consecutive indexes: one on the left of zero and one on the right. The only gaps inside a run are
+// the holes left behind by LREM (or by pushes that reserved an index but failed to write it), which are
+// tracked in a separate partition so that positions can be converted to indexes without reading the list.
+type lLayout struct {
+	runs  [][2]int64
+	holes []int64
+}
 
-	if end < 0 {
-		end = llen + end
+func (l lLayout) holesBetween(low, high int64) int64 {
+	from := sort.Search(len(l.holes), func(i int) bool { return l.holes[i] >= low })
+	to := sort.Search(len(l.holes), func(i int) bool { return l.holes[i] > high })
+
+	return int64(to - from)
+}
+
+func (l lLayout) length() (length int64) {
+	for _, run := range l.runs {
+		length += run[1] - run[0] + 1 - l.holesBetween(run[0], run[1])
 	}
 
-	if start < 0 {
-		start = 0
+	return
+}
+
+// index converts a zero based position in the list into the index of the element stored there.
+func (l lLayout) index(position int64) int64 {
+	for _, run := range l.runs {
+		size := run[1] - run[0] + 1 - l.holesBetween(run[0], run[1])
+		if position >= size {
+			position -= size
+			continue
+		}
+
+		// every hole before the candidate index pushes the element one index further.
+		index := run[0] + position
+		from := sort.Search(len(l.holes), func(i int) bool { return l.holes[i] >= run[0] })
+
+		for i := from; i < len(l.holes) && l.holes[i] <= index; i++ {
+			index++
+		}
+
+		return index
 	}
 
-	if end >= llen {
-		end = llen - 1
+	return 0
+}
+
+// listHolesKey returns the partition that keeps track of the holes inside a list. It doesn't share the
+// _redimo/ prefix of listMetaKey, so it can't collide with the metadata of another list.
+func listHolesKey(key string) string {
+	return fmt.Sprintf("_redimo_holes/%v", key)
+}
+
+func (c Client) lHoleKey(key string, index int64) map[string]types.AttributeValue {
+	return keyDef{pk: listHolesKey(key), sk: strconv.FormatInt(index, 10)}.toAV(c)
+}
+
+func (c Client) lHoleItem(key string, index int64) map[string]types.AttributeValue {
+	item := c.lHoleKey(key, index)
+	item[c.sortKeyNum] = IntValue{index}.ToAV()
+
+	return item
+}
+
+// lAddHole records that nothing is stored at the given index of the list, after a failed attempt to store
+// element there. A failed request might still have been applied, so the hole is only recorded if the
+// element really isn't there.
+func (c Client) lAddHole(key string, element string, index int64) error {
+	builder := newExpresionBuilder()
+	builder.addConditionNotExists(c.partitionKey)
+
+	_, err := c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				ConditionCheck: &types.ConditionCheck{
+					ConditionExpression:      builder.conditionExpression(),
+					ExpressionAttributeNames: builder.expressionAttributeNames(),
+					Key:                      keyDef{pk: key, sk: genSk(element, index)}.toAV(c),
+					TableName:                aws.String(c.tableName),
+				},
+			},
+			{
+				Put: &types.Put{
+					Item:      c.lHoleItem(key, index),
+					TableName: aws.String(c.tableName),
+				},
+			},
+		},
+	})
+
+	if conditionFailureError(err) {
+		return nil
 	}
 
-	if start > end || start >= llen {
-		return elements, items, nil
+	return err
+}
+
+var errListHole = errors.New("could not record an unused list index")
+
+// lRecordHole records the index of a push that failed with cause as a hole, retrying a few times, and returns
+// cause. If the hole can't be recorded, the returned error wraps errListHole instead; the hole will then be
+// found by the next read over it (see lVerify).
+func (c Client) lRecordHole(key string, element string, index int64, cause error) error {
+	var err error
+
+	for retryCount := 0; retryCount < listContentionRetries; retryCount++ {
+		if err = c.lAddHole(key, element, index); err == nil {
+			return cause
+		}
 	}
 
-	count = end - start + 1
+	return fmt.Errorf("%w at %v after %v: %v", errListHole, index, cause, err)
+}
+
+func (c Client) lHoles(key string) (holes []int64, err error) {
+	items, err := c.lIndexQuery(listHolesKey(key), negInf, posInf, true, 0, types.SelectAllProjectedAttributes)
 
-	return c.lGeneralRangeWithItems_(key, start, count, forward, attribute)
+	for _, item := range items {
+		holes = append(holes, ReturnValue{item[c.sortKeyNum]}.Int())
+	}
+
+	return holes, err
 }
 
-func (c Client) lGeneralRangeWithItems_(key string,
-	offset int64, count int64,
-	forward bool, attribute string) (elements []ReturnValue, items []map[string]types.AttributeValue, err error) {
-	elements = make([]ReturnValue, 0)
-	index := int64(0)
-	remainingCount := count
+// lIndexQuery reads the items of the list between the given indexes (inclusive) using the index, stopping
+// after limit items if limit is positive.
+func (c Client) lIndexQuery(key string, start rangeCap, stop rangeCap, forward bool, limit int32, selection types.Select) (items []map[string]types.AttributeValue, err error) {
 	hasMoreResults := true
 
 	var lastKey map[string]types.AttributeValue
 
 	for hasMoreResults {
 		var queryLimit *int32
-		if remainingCount > 0 {
-			queryLimit = aws.Int32(int32(remainingCount) + int32(offset) - int32(index))
+		if limit > 0 {
+			queryLimit = aws.Int32(limit - int32(len(items)))
 		}
 
 		builder := newExpresionBuilder()
 		builder.addConditionEquality(c.partitionKey, StringValue{key})
 
-		var queryIndex *string
-		if attribute == c.sortKeyNum {
-			queryIndex = aws.String(c.indexName)
+		if start.present() {
+			builder.values["start"] = start.ToAV()
+		}
+
+		if stop.present() {
+			builder.values["stop"] = stop.ToAV()
+		}
+
+		switch {
+		case start.present() && stop.present():
+			builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKeyNum), c.sortKeyNum)
+		case start.present():
+			builder.condition(fmt.Sprintf("#%v >= :start", c.sortKeyNum), c.sortKeyNum)
+		case stop.present():
+			builder.condition(fmt.Sprintf("#%v <= :stop", c.sortKeyNum), c.sortKeyNum)
 		}
 
 		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
@@ -363,77 +560,202 @@ func (c Client) lGeneralRangeWithItems_(key string,
 			ExclusiveStartKey:         lastKey,
 			ExpressionAttributeNames:  builder.expressionAttributeNames(),
 			ExpressionAttributeValues: builder.expressionAttributeValues(),
-			IndexName:                 queryIndex,
+			IndexName:                 aws.String(c.indexName),
 			KeyConditionExpression:    builder.conditionExpression(),
 			Limit:                     queryLimit,
 			ScanIndexForward:          aws.Bool(forward),
 			TableName:                 aws.String(c.tableName),
-			Select:                    types.SelectAllAttributes,
+			Select:                    selection,
 		})
 
 		if err != nil {
-			fmt.Printf("Error in lGeneralRange: %v", err)
-			return elements, items, err
+			return items, err
 		}
 
-		for _, item := range resp.Items {
-			if index >= offset {
-				pi := parseItem(item, c)
-				elements = append(elements, pi.val)
-				items = append(items, item)
-				remainingCount--
-			}
-			index++
-		}
+		items = append(items, resp.Items...)
 
-		if len(resp.LastEvaluatedKey) > 0 && remainingCount > 0 {
+		if len(resp.LastEvaluatedKey) > 0 && (limit <= 0 || int32(len(items)) < limit) {
 			lastKey = resp.LastEvaluatedKey
 		} else {
 			hasMoreResults = false
 		}
 	}
 
-	return elements, items, nil
+	return items, nil
 }
 
-func (c Client) LRANGE(key string, start, stop int64) (elements []ReturnValue, err error) {
-	return c.lRange(key, start, stop, true)
-}
+// lBoundary returns the first (or last) index used between start and stop.
+func (c Client) lBoundary(key string, start rangeCap, stop rangeCap, forward bool) (index int64, found bool, err error) {
+	items, err := c.lIndexQuery(key, start, stop, forward, 1, types.SelectAllProjectedAttributes)
+	if err != nil || len(items) == 0 {
+		return 0, false, err
+	}
 
-func (c Client) RPOP(key string) (element ReturnValue, err error) {
-	_, items, err := c.lGeneralRangeWithItems(key, 0, 1, false, c.sortKeyNum)
+	return ReturnValue{items[0][c.sortKeyNum]}.Int(), true, nil
+}
 
+// lEndItem returns the element at the head (or tail) of the list, or nil if the list is empty.
+func (c Client) lEndItem(key string, left bool) (item map[string]types.AttributeValue, err error) {
+	items, err := c.lIndexQuery(key, negInf, posInf, left, 1, types.SelectAllAttributes)
 	if err != nil || len(items) == 0 {
-		return element, err
+		return nil, err
 	}
 
-	// delete item 0
-	sk := items[0][c.sortKey].(*types.AttributeValueMemberS).Value
+	return items[0], nil
+}
 
-	result, err := c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-		Key:                      keyDef{pk: key, sk: sk}.toAV(c),
-		TableName:                aws.String(c.tableName),
-		ReturnValues:             types.ReturnValueAllOld,
-		ConditionExpression:      aws.String("attribute_exists(#pk)"), // ← 确保元素存在
-		ExpressionAttributeNames: map[string]string{"#pk": c.partitionKey},
-	})
+func (c Client) lLayout(key string) (layout lLayout, err error) {
+	head, found, err := c.lBoundary(key, negInf, posInf, true)
+	if err != nil || !found {
+		return layout, err
+	}
 
-	if conditionFailureError(err) {
-		// 元素已被其他线程删除，返回空
-		return ReturnValue{}, nil
+	tail, found, err := c.lBoundary(key, negInf, posInf, false)
+	if err != nil || !found {
+		return layout, err
 	}
 
-	if err != nil {
-		return element, err
+	if head < 0 && tail > 0 {
+		leftTail, leftFound, err := c.lBoundary(key, negInf, zScore{-1}, false)
+		if err != nil {
+			return layout, err
+		}
+
+		rightHead, rightFound, err := c.lBoundary(key, zScore{1}, posInf, true)
+		if err != nil {
+			return layout, err
+		}
+
+		if leftFound {
+			layout.runs = append(layout.runs, [2]int64{head, leftTail})
+		}
+
+		if rightFound {
+			layout.runs = append(layout.runs, [2]int64{rightHead, tail})
+		}
+	} else {
+		layout.runs = [][2]int64{{head, tail}}
 	}
 
-	if result.Attributes == nil {
-		return element, nil
+	layout.holes, err = c.lHoles(key)
+
+	return layout, err
+}
+
+// lRangeItems returns the items between the start and end positions (inclusive, negative positions count
+// from the tail) with a single range query on the index, without reading the elements before start.
+//
+// The items read are checked against the layout with lVerify, and if the holes were off the layout is
+// corrected and the items read again.
+func (c Client) lRangeItems(key string, start int64, end int64, forward bool) (items []map[string]types.AttributeValue, err error) {
+	for retryCount := 0; retryCount < listContentionRetries; retryCount++ {
+		layout, err := c.lLayout(key)
+		if err != nil {
+			return items, err
+		}
+
+		llen := layout.length()
+
+		from, to := c.normalizeStartStop(llen, start, end)
+		if from == -1 {
+			return nil, nil
+		}
+
+		if !forward {
+			from, to = llen-1-to, llen-1-from
+		}
+
+		low, high := layout.index(from), layout.index(to)
+
+		items, err = c.lIndexQuery(key, zScore{float64(low)}, zScore{float64(high)}, forward, 0, types.SelectAllAttributes)
+		if err != nil {
+			return items, err
+		}
+
+		consistent, err := c.lVerify(key, layout, low, high, items)
+		if err != nil || consistent {
+			return items, err
+		}
+	}
+
+	return items, errors.New("too much contention")
+}
+
+// lVerify checks the items read between the low and high indexes against the layout they were located with,
+// and fixes the recorded holes if they don't match: an index that was reserved by a push that never completed
+// (because the process crashed, say) is an untracked hole, and the record of a hole can be outdated if the
+// write that was thought to have failed went through after all. It reports whether the layout was correct.
+//
+// An index can also be missing because a concurrent pop just removed it from the end of a run, which doesn't
+// make it a hole, so the boundaries of the list are read again before recording holes, and only indexes
+// strictly inside the current runs are recorded.
+func (c Client) lVerify(key string, layout lLayout, low int64, high int64, items []map[string]types.AttributeValue) (consistent bool, err error) {
+	present := make(map[int64]struct{}, len(items))
+	for _, item := range items {
+		present[ReturnValue{item[c.sortKeyNum]}.Int()] = struct{}{}
 	}
 
-	element = parseItem(items[0], c).val
+	holes := make(map[int64]struct{}, len(layout.holes))
+	for _, hole := range layout.holes {
+		holes[hole] = struct{}{}
+	}
 
-	return
+	var requests []types.WriteRequest
+
+	var missing []int64
+
+	for _, run := range layout.runs {
+		from, to := run[0], run[1]
+		if from < low {
+			from = low
+		}
+
+		if to > high {
+			to = high
+		}
+
+		for index := from; index <= to; index++ {
+			_, isPresent := present[index]
+			_, isHole := holes[index]
+
+			switch {
+			case isPresent && isHole:
+				requests = append(requests, c.deleteRequest(c.lHoleKey(key, index)))
+			case !isPresent && !isHole:
+				missing = append(missing, index)
+			}
+		}
+	}
+
+	if len(requests) == 0 && len(missing) == 0 {
+		return true, nil
+	}
+
+	if len(missing) > 0 {
+		current, err := c.lLayout(key)
+		if err != nil {
+			return false, err
+		}
+
+		for _, index := range missing {
+			for _, run := range current.runs {
+				if run[0] < index && index < run[1] {
+					requests = append(requests, c.putRequest(c.lHoleItem(key, index)))
+					break
+				}
+			}
+		}
+	}
+
+	return false, c.batchWriteItems(requests)
+}
+
+func (c Client) LRANGE(key string, start, stop int64) (elements []ReturnValue, err error) {
+	return c.lRange(key, start, stop, true)
+}
+
+func (c Client) RPOP(key string) (element ReturnValue, err error) {
+	return c.lPop(key, false)
 }
 
 func (c Client) LPUSHX(key string, elements ...interface{}) (newLength int64, err error) {
@@ -478,7 +800,7 @@ func (c Client) RPOPLPUSH(sourceKey string, destinationKey string) (element Retu
 
 func (c Client) LSET(key string, index int64, element string) (ok bool, err error) {
 	// get the element at the index
-	_, items, err := c.lGeneralRangeWithItems(key, index, 1, true, c.sortKeyNum)
+	items, err := c.lRangeItems(key, index, index, true)
 
 	if err != nil || len(items) == 0 {
 		return false, err
@@ -525,7 +847,8 @@ func (c Client) LSET(key string, index int64, element string) (ok bool, err erro
 	})
 
 	if err != nil {
-		return false, nil
+		// the old element is gone, so its index is now a hole.
+		return false, c.lRecordHole(key, element, sknn, err)
 	}
 
 	return true, err
@@ -683,16 +1006,28 @@ func (c Client) LREM(key string, count int64, element interface{}) (newLength in
 		count = int64(len(items))
 	}
 
-	// delete [count] items with condition to prevent concurrent issues
+	// delete [count] items with condition to prevent concurrent issues, recording the hole each one leaves
 	actualDeleted := int64(0)
 	for i := int64(0); i < count; i++ {
 		item := items[i]
 
-		_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			Key:                      keyDef{pk: key, sk: item[c.sortKey].(*types.AttributeValueMemberS).Value}.toAV(c),
-			TableName:                aws.String(c.tableName),
-			ConditionExpression:      aws.String("attribute_exists(#pk)"),
-			ExpressionAttributeNames: map[string]string{"#pk": c.partitionKey},
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{
+				{
+					Delete: &types.Delete{
+						Key:                      keyDef{pk: key, sk: item[c.sortKey].(*types.AttributeValueMemberS).Value}.toAV(c),
+						TableName:                aws.String(c.tableName),
+						ConditionExpression:      aws.String("attribute_exists(#pk)"),
+						ExpressionAttributeNames: map[string]string{"#pk": c.partitionKey},
+					},
+				},
+				{
+					Put: &types.Put{
+						Item:      c.lHoleItem(key, ReturnValue{item[c.sortKeyNum]}.Int()),
+						TableName: aws.String(c.tableName),
+					},
+				},
+			},
 		})
 
 		if conditionFailureError(err) {
@@ -706,12 +1041,154 @@ func (c Client) LREM(key string, count int64, element interface{}) (newLength in
 		actualDeleted++
 	}
 
-	newLength, err = c.LLEN(key)
+	layout, err := c.lLayout(key)
 	if err != nil {
 		return 0, false, err
 	}
 
-	return newLength, true, nil
+	if len(layout.holes) >= listCompactionHoles {
+		if _, err = c.LCOMPACT(key); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return layout.length(), true, nil
+}
+
+// LCOMPACT removes the holes that LREM leaves inside the list, so that LINDEX, LRANGE and LLEN don't have
+// to account for them. Elements left of zero are packed towards the head and elements right of zero towards
+// the tail, so the indexes that are vacated are never handed out again by LPUSH or RPUSH, and the order of
+// the elements is preserved. Each element is moved in its own transaction; if the list is modified
+// concurrently the compaction stops early and can simply be run again.
+//
+// LREM compacts the list automatically once enough holes have accumulated, so there's usually no need to call this.
+// The gaps are found by reading the list rather than from the recorded holes, so this also closes the gaps left
+// by pushes that reserved an index but never completed.
+//
+// Cost is O(N) to read the list, plus one 4 item transaction for each element that needs to move.
+func (c Client) LCOMPACT(key string) (moved int64, err error) {
+	layout, err := c.lLayout(key)
+	if err != nil {
+		return moved, err
+	}
+
+	for _, run := range layout.runs {
+		items, err := c.lIndexQuery(key, zScore{float64(run[0])}, zScore{float64(run[1])}, true, 0, types.SelectAllAttributes)
+		if err != nil {
+			return moved, err
+		}
+
+		if int64(len(items)) == run[1]-run[0]+1 {
+			continue
+		}
+
+		for i := range items {
+			item, to := items[i], run[0]+int64(i)
+
+			if run[0] > 0 {
+				// walk backwards so that elements are packed towards the tail.
+				item, to = items[len(items)-1-i], run[1]-int64(i)
+			}
+
+			from := ReturnValue{item[c.sortKeyNum]}.Int()
+			if from == to {
+				continue
+			}
+
+			ok, err := c.lMoveItem(key, item, from, to)
+			if err != nil || !ok {
+				return moved, err
+			}
+
+			moved++
+		}
+	}
+
+	// drop the holes that are now outside of the list.
+	layout, err = c.lLayout(key)
+	if err != nil {
+		return moved, err
+	}
+
+	for _, hole := range layout.holes {
+		inside := false
+		for _, run := range layout.runs {
+			inside = inside || (hole >= run[0] && hole <= run[1])
+		}
+
+		if inside {
+			continue
+		}
+
+		_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			Key:       c.lHoleKey(key, hole),
+			TableName: aws.String(c.tableName),
+		})
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// lMoveItem moves a list element to another index, turning its old index into a hole and filling the hole at
+// the new one. It reports false if the element or the target index changed concurrently.
+func (c Client) lMoveItem(key string, item map[string]types.AttributeValue, from int64, to int64) (ok bool, err error) {
+	element := ReturnValue{item[vk]}.String()
+
+	moved := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		moved[k] = v
+	}
+
+	moved[c.sortKey] = StringValue{genSk(element, to)}.ToAV()
+	moved[c.sortKeyNum] = IntValue{to}.ToAV()
+
+	exists := newExpresionBuilder()
+	exists.addConditionExists(c.partitionKey)
+
+	notExists := newExpresionBuilder()
+	notExists.addConditionNotExists(c.partitionKey)
+
+	_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					ConditionExpression:      exists.conditionExpression(),
+					ExpressionAttributeNames: exists.expressionAttributeNames(),
+					Key:                      keyDef{pk: key, sk: item[c.sortKey].(*types.AttributeValueMemberS).Value}.toAV(c),
+					TableName:                aws.String(c.tableName),
+				},
+			},
+			{
+				Put: &types.Put{
+					ConditionExpression:      notExists.conditionExpression(),
+					ExpressionAttributeNames: notExists.expressionAttributeNames(),
+					Item:                     moved,
+					TableName:                aws.String(c.tableName),
+				},
+			},
+			{
+				Put: &types.Put{
+					Item:      c.lHoleItem(key, from),
+					TableName: aws.String(c.tableName),
+				},
+			},
+			{
+				Delete: &types.Delete{
+					Key:       c.lHoleKey(key, to),
+					TableName: aws.String(c.tableName),
+				},
+			},
+		},
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
 }
 
 func (c Client) normalizeStartStop(llen int64, start int64, stop int64) (int64, int64) {
@@ -754,7 +1231,7 @@ func (c Client) lDelete(key string, start int64, stop int64) (newLength int64, e
 		return llen, nil
 	}
 
-	_, items, err := c.lGeneralRangeWithItems(key, start, stop-start+1, true, c.sortKeyNum)
+	items, err := c.lRangeItems(key, start, stop, true)
 
 	if err != nil {
 		return llen, err
@@ -811,3 +1288,85 @@ func (c Client) LTRIM(key string, start int64, stop int64) (newLength int64, err
 	llen, err = c.lDelete(key, 0, start-1)
 	return llen, err
 }
+
+// LMPOP pops up to count elements from the given side of the first non-empty list among the given keys,
+// and returns the key the elements were popped from. If all the lists are empty, the returned key is empty.
+//
+// Each element is popped with a separate conditional delete, so elements being popped concurrently by
+// other clients are skipped rather than returned twice.
+//
+// Works similar to https://redis.io/commands/lmpop
+func (c Client) LMPOP(side LSide, count int64, keys ...string) (key string, elements []ReturnValue, err error) {
+	for _, key := range keys {
+		for int64(len(elements)) < count {
+			var element ReturnValue
+
+			if side == Left {
+				element, err = c.LPOP(key)
+			} else {
+				element, err = c.RPOP(key)
+			}
+
+			if err != nil {
+				return key, elements, err
+			}
+
+			if element.Empty() {
+				break
+			}
+
+			elements = append(elements, element)
+		}
+
+		if len(elements) > 0 {
+			return key, elements, nil
+		}
+	}
+
+	return "", elements, nil
+}
+
+// BLPOP is the blocking version of LPOP. It pops the first element of the first non-empty list among the given keys,
+// waiting until an element is available, the timeout expires or the context is cancelled. A zero timeout waits indefinitely.
+//
+// If the timeout expires, the returned key is empty and there is no error. If the context is cancelled, its error is returned.
+//
+// DynamoDB has no way to push changes to clients, so the lists are polled with an adaptive backoff: the wait between
+// polls starts at 10ms and doubles up to one second. If the client has a PushNotifier attached (see Client.Notifier),
+// pushes made in this process wake the waiting call immediately.
+//
+// Works similar to https://redis.io/commands/blpop
+func (c Client) BLPOP(ctx context.Context, timeout time.Duration, keys ...string) (key string, element ReturnValue, err error) {
+	key, elements, err := c.BLMPOP(ctx, timeout, Left, 1, keys...)
+	if len(elements) > 0 {
+		element = elements[0]
+	}
+
+	return
+}
+
+// BRPOP is the blocking version of RPOP, and works the same way as BLPOP except that
+// elements are popped from the tail of the lists.
+//
+// Works similar to https://redis.io/commands/brpop
+func (c Client) BRPOP(ctx context.Context, timeout time.Duration, keys ...string) (key string, element ReturnValue, err error) {
+	key, elements, err := c.BLMPOP(ctx, timeout, Right, 1, keys...)
+	if len(elements) > 0 {
+		element = elements[0]
+	}
+
+	return
+}
+
+// BLMPOP is the blocking version of LMPOP. It waits, as described in BLPOP, until at least one of the lists
+// is non-empty and then pops up to count elements from the given side of it.
+//
+// Works similar to https://redis.io/commands/blmpop
+func (c Client) BLMPOP(ctx context.Context, timeout time.Duration, side LSide, count int64, keys ...string) (key string, elements []ReturnValue, err error) {
+	_, err = c.block(ctx, timeout, keys, func() (bool, error) {
+		key, elements, err = c.LMPOP(side, count, keys...)
+		return len(elements) > 0, err
+	})
+
+	return
+}
@@ -1,8 +1,11 @@
 package redimo
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -329,3 +332,240 @@ func TestListValueBasedCRUD(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, ok)
 }
+
+func TestListHolesAndCompaction(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.RPUSH("l1", "c", "x", "d", "x", "e")
+	assert.NoError(t, err)
+
+	_, err = c.LPUSH("l1", "b", "x", "a")
+	assert.NoError(t, err)
+
+	newLength, ok, err := c.LREM("l1", 0, "x")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), newLength)
+
+	elements, err := c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, readStrings(elements))
+
+	elements, err = c.LRANGE("l1", 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, readStrings(elements))
+
+	element, err := c.LINDEX("l1", -2)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", element.String())
+
+	element, err = c.LINDEX("l1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", element.String())
+
+	moved, err := c.LCOMPACT("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), moved)
+
+	moved, err = c.LCOMPACT("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), moved)
+
+	length, err := c.LLEN("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), length)
+
+	elements, err = c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, readStrings(elements))
+
+	ok, err = c.LSET("l1", 2, "C")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = c.RPUSH("l1", "f")
+	assert.NoError(t, err)
+
+	_, err = c.LPUSH("l1", "z")
+	assert.NoError(t, err)
+
+	elements, err = c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"z", "a", "b", "C", "d", "e", "f"}, readStrings(elements))
+}
+
+func TestListUntrackedHoles(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.RPUSH("l1", "a")
+	assert.NoError(t, err)
+
+	// an index reserved by a push that never got to write its element.
+	_, err = c.HINCRBY(listMetaKey("l1"), ListSKIndexRight, 1)
+	assert.NoError(t, err)
+
+	_, err = c.RPUSH("l1", "b", "c")
+	assert.NoError(t, err)
+
+	_, err = c.RPUSH("holes/l1", "unrelated")
+	assert.NoError(t, err)
+
+	length, err := c.LLEN("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), length)
+
+	elements, err := c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, readStrings(elements))
+
+	length, err = c.LLEN("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), length)
+
+	element, err := c.LINDEX("l1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", element.String())
+
+	_, err = c.HINCRBY(listMetaKey("l1"), ListSKIndexRight, 1)
+	assert.NoError(t, err)
+
+	_, err = c.RPUSH("l1", "d")
+	assert.NoError(t, err)
+
+	moved, err := c.LCOMPACT("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), moved)
+
+	length, err = c.LLEN("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), length)
+
+	elements, err = c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, readStrings(elements))
+
+	length, err = c.LLEN("holes/l1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), length)
+
+	// an index popped from the end after the layout was read is not a hole.
+	layout, err := c.lLayout("l1")
+	assert.NoError(t, err)
+
+	_, err = c.RPOP("l1")
+	assert.NoError(t, err)
+
+	low, high := layout.index(0), layout.index(layout.length()-1)
+	items, err := c.lIndexQuery("l1", zScore{float64(low)}, zScore{float64(high)}, true, 0, types.SelectAllAttributes)
+	assert.NoError(t, err)
+
+	consistent, err := c.lVerify("l1", layout, low, high, items)
+	assert.NoError(t, err)
+	assert.False(t, consistent)
+
+	holes, err := c.lHoles("l1")
+	assert.NoError(t, err)
+	assert.Empty(t, holes)
+
+	// DEL drops the holes along with the list, so a new list under the same key doesn't inherit them.
+	_, err = c.HINCRBY(listMetaKey("l1"), ListSKIndexRight, 1)
+	assert.NoError(t, err)
+
+	_, err = c.RPUSH("l1", "e")
+	assert.NoError(t, err)
+
+	_, err = c.LRANGE("l1", 0, -1)
+	assert.NoError(t, err)
+
+	holes, err = c.lHoles("l1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(holes))
+
+	_, err = c.DEL("l1")
+	assert.NoError(t, err)
+
+	holes, err = c.lHoles("l1")
+	assert.NoError(t, err)
+	assert.Empty(t, holes)
+}
+
+func TestCappedPush(t *testing.T) {
+	c := newClient(t).TransactionActions(3)
+
+	for _, event := range []string{"e1", "e2", "e3", "e4", "e5"} {
+		length, err := c.LPUSHCAPPED("events", 3, event)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, length, int64(3))
+	}
+
+	elements, err := c.LRANGE("events", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e5", "e4", "e3"}, readStrings(elements))
+
+	length, err := c.LPUSHCAPPED("events", 3, "e6", "e7")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), length)
+
+	elements, err = c.LRANGE("events", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e7", "e6", "e5"}, readStrings(elements))
+
+	length, err = c.RPUSHCAPPED("log", 2, "a", "b", "c", "d")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), length)
+
+	length, err = c.RPUSHCAPPED("log", 2, "e")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), length)
+
+	elements, err = c.LRANGE("log", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "e"}, readStrings(elements))
+}
+
+func TestBlockingPops(t *testing.T) {
+	c := newClient(t).Notifier(NewPushNotifier())
+
+	_, err := c.RPUSH("l2", "one", "two", "three")
+	assert.NoError(t, err)
+
+	key, element, err := c.BLPOP(context.Background(), time.Second, "l1", "l2")
+	assert.NoError(t, err)
+	assert.Equal(t, "l2", key)
+	assert.Equal(t, "one", element.String())
+
+	key, element, err = c.BRPOP(context.Background(), time.Second, "l1", "l2")
+	assert.NoError(t, err)
+	assert.Equal(t, "l2", key)
+	assert.Equal(t, "three", element.String())
+
+	key, elements, err := c.BLMPOP(context.Background(), time.Second, Left, 5, "l1", "l2")
+	assert.NoError(t, err)
+	assert.Equal(t, "l2", key)
+	assert.Equal(t, []string{"two"}, readStrings(elements))
+
+	start := time.Now()
+	key, element, err = c.BLPOP(context.Background(), 200*time.Millisecond, "l1", "l2")
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+	assert.True(t, element.Empty())
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err = c.BLPOP(ctx, 0, "l1")
+	assert.Equal(t, context.Canceled, err)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := c.LPUSH("l1", "wakeup")
+		assert.NoError(t, err)
+	}()
+
+	key, element, err = c.BLPOP(context.Background(), 10*time.Second, "l1", "l2")
+	assert.NoError(t, err)
+	assert.Equal(t, "l1", key)
+	assert.Equal(t, "wakeup", element.String())
+}
@@ -0,0 +1,318 @@
+package redimo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/oklog/ulid"
+)
+
+const queueIDKey = "qid"
+
+const queueContentionRetries = 5
+
+// Queue is a reliable work queue built on a redimo list, implementing the "pop, process, ack, requeue on timeout"
+// pattern usually built with RPOPLPUSH.
+//
+// Messages are enqueued at the tail of the list named after the queue, and dequeued from its head. A dequeued
+// message is moved into a processing area with a lease, where it stays until it is acknowledged with Ack. If the
+// lease expires first, the reaper (see Reap and RunReaper) puts the message back at the head of the list so that
+// another consumer can pick it up. A message that has already been delivered MaxAttempts times is moved to
+// the dead-letter list instead.
+//
+// Delivery counts are kept on the list items themselves, and lease expiry times are stored in the numeric sort key
+// of the processing items, so the reaper can find expired leases with a range query on the index.
+//
+// The queue and dead-letter lists are regular lists, so they can be inspected with LLEN, LRANGE, etc.
+type Queue struct {
+	c             Client
+	name          string
+	maxAttempts   int64
+	deadLetterKey string
+}
+
+// QueueMessage is a message delivered by a Queue. The ID is assigned when the message is enqueued and
+// does not change across deliveries; it is used to Ack or Nack the message.
+type QueueMessage struct {
+	ID            string
+	Body          ReturnValue
+	DeliveryCount int64
+	LeaseExpires  time.Time
+}
+
+// NewQueue creates a queue stored in the list at the given key. By default messages are redelivered
+// indefinitely, use MaxAttempts to enable dead-lettering.
+func NewQueue(c Client, name string) Queue {
+	return Queue{
+		c:             c,
+		name:          name,
+		deadLetterKey: name + "/dead-letter",
+	}
+}
+
+// MaxAttempts sets the number of deliveries after which a message that was not acknowledged is moved to the
+// dead-letter list instead of being redelivered. Zero, the default, disables dead-lettering.
+func (q Queue) MaxAttempts(attempts int64) Queue {
+	q.maxAttempts = attempts
+	return q
+}
+
+// DeadLetter sets the key of the dead-letter list. The default is the queue name followed by "/dead-letter".
+func (q Queue) DeadLetter(key string) Queue {
+	q.deadLetterKey = key
+	return q
+}
+
+func (q Queue) processingKey() string {
+	return strings.Join([]string{"_redimo", "queue", q.name, "processing"}, "/")
+}
+
+func timeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// newULID returns a new, lexicographically sortable unique ID.
+func newULID() (string, error) {
+	id, err := ulid.New(ulid.Now(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// Enqueue adds a message to the tail of the queue and returns its ID.
+//
+// Cost is O(1) / 2 WCUs, one to reserve the list index and one to store the message.
+func (q Queue) Enqueue(body string) (id string, err error) {
+	id, err = newULID()
+	if err != nil {
+		return id, err
+	}
+
+	action, index, err := q.c.lPutAction(q.name, false, body, map[string]types.AttributeValue{
+		queueIDKey: StringValue{id}.ToAV(),
+	})
+	if err != nil {
+		return id, err
+	}
+
+	_, err = q.c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{action},
+	})
+	if err != nil {
+		return id, q.c.lRecordHole(q.name, body, index, err)
+	}
+
+	q.c.notifier.notify(q.c, q.name)
+
+	return id, nil
+}
+
+// Dequeue claims the message at the head of the queue, leasing it for the given visibility timeout. If the
+// queue is empty, ok will be false. The message must be acknowledged with Ack before the lease expires,
+// otherwise it will be redelivered once the reaper runs.
+//
+// The message is moved from the list to the processing area in a single transaction, so a message is
+// never delivered to two consumers at the same time.
+func (q Queue) Dequeue(visibilityTimeout time.Duration) (message QueueMessage, ok bool, err error) {
+	for retryCount := 0; retryCount < queueContentionRetries; retryCount++ {
+		item, err := q.c.lEndItem(q.name, true)
+		if err != nil || item == nil {
+			return message, false, err
+		}
+
+		message = QueueMessage{
+			ID:            ReturnValue{item[queueIDKey]}.String(),
+			Body:          ReturnValue{item[vk]},
+			DeliveryCount: ReturnValue{item[deliveryCountKey]}.Int() + 1,
+			LeaseExpires:  time.Now().Add(visibilityTimeout),
+		}
+
+		// Elements pushed onto the list directly with LPUSH / RPUSH don't have an ID yet.
+		if message.ID == "" {
+			message.ID, err = newULID()
+			if err != nil {
+				return message, false, err
+			}
+		}
+
+		builder := newExpresionBuilder()
+		builder.addConditionExists(q.c.partitionKey)
+
+		err = q.c.takeLease(parseKey(item, q.c), builder, message.processingItem(q))
+
+		if conditionFailureError(err) {
+			// Another consumer claimed the message first, try the next one.
+			continue
+		}
+
+		if err != nil {
+			return message, false, err
+		}
+
+		return message, true, nil
+	}
+
+	return QueueMessage{}, false, errors.New("too much contention")
+}
+
+// BDequeue is the blocking version of Dequeue. It waits until a message is available, the timeout
+// expires or the context is cancelled, in the same way as BLPOP.
+func (q Queue) BDequeue(ctx context.Context, timeout time.Duration, visibilityTimeout time.Duration) (message QueueMessage, ok bool, err error) {
+	_, err = q.c.block(ctx, timeout, []string{q.name}, func() (bool, error) {
+		message, ok, err = q.Dequeue(visibilityTimeout)
+		return ok, err
+	})
+
+	return
+}
+
+func (m QueueMessage) processingItem(q Queue) map[string]types.AttributeValue {
+	item := keyDef{pk: q.processingKey(), sk: m.ID}.toAV(q.c)
+	item[q.c.sortKeyNum] = IntValue{timeToMillis(m.LeaseExpires)}.ToAV()
+	item[vk] = m.Body.ToAV()
+	item[deliveryCountKey] = IntValue{m.DeliveryCount}.ToAV()
+
+	return item
+}
+
+func parseQueueMessage(item map[string]types.AttributeValue, c Client) QueueMessage {
+	return QueueMessage{
+		ID:            parseKey(item, c).sk,
+		Body:          ReturnValue{item[vk]},
+		DeliveryCount: ReturnValue{item[deliveryCountKey]}.Int(),
+		LeaseExpires:  millisToTime(ReturnValue{item[c.sortKeyNum]}.Int()),
+	}
+}
+
+// Ack acknowledges a message that was successfully processed, removing it from the queue permanently.
+// If the message is no longer leased – because it was already acknowledged, or its lease expired and it
+// was returned to the queue by the reaper – ok will be false.
+func (q Queue) Ack(id string) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionExists(q.c.partitionKey)
+
+	_, err = q.c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: q.processingKey(), sk: id}.toAV(q.c),
+		TableName:                 aws.String(q.c.tableName),
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Nack gives up the lease on a message without waiting for it to expire, returning the message to the head
+// of the queue, or moving it to the dead-letter list if it has been delivered MaxAttempts times. If the message
+// is no longer leased, ok will be false.
+func (q Queue) Nack(id string) (ok bool, err error) {
+	resp, err := q.c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            keyDef{pk: q.processingKey(), sk: id}.toAV(q.c),
+		TableName:      aws.String(q.c.tableName),
+	})
+	if err != nil || len(resp.Item) == 0 {
+		return false, err
+	}
+
+	_, ok, err = q.release(parseQueueMessage(resp.Item, q.c))
+
+	return
+}
+
+// release moves a leased message back to the queue, or to the dead-letter list. The processing item is only
+// deleted if it still holds the same lease, so a message that was acknowledged or re-leased concurrently is left alone.
+func (q Queue) release(message QueueMessage) (deadLettered bool, ok bool, err error) {
+	target, left := q.name, true
+
+	if q.maxAttempts > 0 && message.DeliveryCount >= q.maxAttempts {
+		target, left, deadLettered = q.deadLetterKey, false, true
+	}
+
+	put, index, err := q.c.lPutAction(target, left, message.Body.String(), map[string]types.AttributeValue{
+		queueIDKey:       StringValue{message.ID}.ToAV(),
+		deliveryCountKey: IntValue{message.DeliveryCount}.ToAV(),
+	})
+	if err != nil {
+		return deadLettered, false, err
+	}
+
+	err = q.c.releaseLease(q.processingKey(), message.ID, message.LeaseExpires, put)
+
+	if err != nil {
+		if err = q.c.lRecordHole(target, message.Body.String(), index, err); errors.Is(err, errListHole) {
+			return deadLettered, false, err
+		}
+	}
+
+	if conditionFailureError(err) {
+		return deadLettered, false, nil
+	}
+
+	if err != nil {
+		return deadLettered, false, err
+	}
+
+	q.c.notifier.notify(q.c, target)
+
+	return deadLettered, true, nil
+}
+
+// Reap returns every message whose lease has expired to the head of the queue, or to the dead-letter list
+// if it has been delivered MaxAttempts times, and reports how many messages were moved to each.
+//
+// Cost is O(N) where N is the number of expired leases, plus a range query on the index.
+func (q Queue) Reap() (requeued int, deadLettered int, err error) {
+	err = q.c.expiredLeases(q.processingKey(), time.Now(), func(item map[string]types.AttributeValue) error {
+		dead, ok, err := q.release(parseQueueMessage(item, q.c))
+
+		switch {
+		case ok && dead:
+			deadLettered++
+		case ok:
+			requeued++
+		}
+
+		return err
+	})
+
+	return
+}
+
+// RunReaper calls Reap at the given interval until the context is cancelled, and returns the
+// context's error. If Reap fails, the error is returned immediately.
+func (q Queue) RunReaper(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, _, err := q.Reap(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package redimo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	c := newClient(t)
+	q := NewQueue(c, "jobs").MaxAttempts(2)
+
+	id1, err := q.Enqueue("one")
+	assert.NoError(t, err)
+
+	id2, err := q.Enqueue("two")
+	assert.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+
+	length, err := c.LLEN("jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), length)
+
+	message, ok, err := q.Dequeue(time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, id1, message.ID)
+	assert.Equal(t, "one", message.Body.String())
+	assert.Equal(t, int64(1), message.DeliveryCount)
+
+	ok, err = q.Ack(message.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = q.Ack(message.ID)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	message, ok, err = q.Dequeue(time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, id2, message.ID)
+
+	_, ok, err = q.Dequeue(time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = q.Nack(message.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	message, ok, err = q.Dequeue(time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, id2, message.ID)
+	assert.Equal(t, int64(2), message.DeliveryCount)
+
+	time.Sleep(10 * time.Millisecond)
+
+	requeued, deadLettered, err := q.Reap()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assert.Equal(t, 1, deadLettered)
+
+	length, err = c.LLEN("jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), length)
+
+	elements, err := c.LRANGE("jobs/dead-letter", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"two"}, readStrings(elements))
+}
+
+func TestQueueReaper(t *testing.T) {
+	c := newClient(t)
+	q := NewQueue(c, "jobs")
+
+	id, err := q.Enqueue("one")
+	assert.NoError(t, err)
+
+	_, err = c.RPUSH("jobs", "pushed directly")
+	assert.NoError(t, err)
+
+	message, ok, err := q.Dequeue(time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, id, message.ID)
+
+	message, ok, err = q.Dequeue(time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NotEmpty(t, message.ID)
+	assert.Equal(t, "pushed directly", message.Body.String())
+
+	time.Sleep(10 * time.Millisecond)
+
+	requeued, deadLettered, err := q.Reap()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assert.Equal(t, 0, deadLettered)
+
+	ok, err = q.Ack(id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	message, ok, err = q.Dequeue(time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, id, message.ID)
+	assert.Equal(t, int64(2), message.DeliveryCount)
+}
@@ -0,0 +1,415 @@
+package redimo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const zRankContentionRetries = 5
+
+// zRankIndex splits the expected score range of the sorted sets into equal buckets, and keeps the number of
+// members in each bucket in a Fenwick tree, so that the number of members below a score can be found with
+// O(log buckets) reads plus a count inside a single bucket.
+type zRankIndex struct {
+	min     float64
+	max     float64
+	buckets int
+}
+
+// zRankKey returns the hash that holds the Fenwick tree nodes of the sorted set at key.
+func zRankKey(key string) string {
+	return fmt.Sprintf("_redimo/zrank/%v", key)
+}
+
+// low returns the lowest score in bucket b. The first bucket also holds every score below min.
+func (ri *zRankIndex) low(b int) float64 {
+	if b <= 1 {
+		return math.Inf(-1)
+	}
+
+	return ri.min + float64(b-1)*(ri.max-ri.min)/float64(ri.buckets)
+}
+
+// bucket returns the bucket the score falls in, between 1 and the number of buckets. Scores outside the range
+// of the index go to the first or last bucket.
+func (ri *zRankIndex) bucket(score float64) int {
+	b := 1
+	if ri.max > ri.min {
+		b = int(math.Floor((score-ri.min)/(ri.max-ri.min)*float64(ri.buckets))) + 1
+	}
+
+	if b < 1 || math.IsNaN(score) {
+		b = 1
+	}
+
+	if b > ri.buckets {
+		b = ri.buckets
+	}
+
+	// make sure the bucket agrees with low() despite any rounding.
+	for b > 1 && score < ri.low(b) {
+		b--
+	}
+
+	for b < ri.buckets && score >= ri.low(b+1) {
+		b++
+	}
+
+	return b
+}
+
+// zRankNodeActions returns the updates to the Fenwick tree nodes for the given changes in bucket counts. The
+// changes are merged per node, since a transaction can't touch the same node twice.
+func (c Client) zRankNodeActions(key string, changes map[int]int64) (actions []types.TransactWriteItem) {
+	deltas := make(map[int]int64)
+
+	for b, delta := range changes {
+		for i := b; i <= c.rankIndex.buckets; i += i & -i {
+			deltas[i] += delta
+		}
+	}
+
+	for node, delta := range deltas {
+		if delta == 0 {
+			continue
+		}
+
+		actions = append(actions, types.TransactWriteItem{
+			Update: &types.Update{
+				ExpressionAttributeNames:  map[string]string{"#val": vk},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":delta": IntValue{delta}.ToAV()},
+				Key:                       keyDef{pk: zRankKey(key), sk: strconv.Itoa(node)}.toAV(c),
+				TableName:                 aws.String(c.tableName),
+				UpdateExpression:          aws.String("ADD #val :delta"),
+			},
+		})
+	}
+
+	return
+}
+
+// zRankScoreActions returns the rank index updates for members of the sorted set at key that left the removed
+// scores and entered the added ones, for writes that can't go through zRankedWrite because they carry more
+// than a score. It returns nothing if the client has no rank index.
+func (c Client) zRankScoreActions(key string, removed []float64, added []float64) []types.TransactWriteItem {
+	if c.rankIndex == nil {
+		return nil
+	}
+
+	changes := make(map[int]int64)
+
+	for _, score := range removed {
+		changes[c.rankIndex.bucket(score)]--
+	}
+
+	for _, score := range added {
+		changes[c.rankIndex.bucket(score)]++
+	}
+
+	return c.zRankNodeActions(key, changes)
+}
+
+// zRankPrefix returns the number of members in the buckets up to and including b.
+func (c Client) zRankPrefix(key string, b int) (count int64, err error) {
+	if b > c.rankIndex.buckets {
+		b = c.rankIndex.buckets
+	}
+
+	var keys []map[string]types.AttributeValue
+
+	for i := b; i > 0; i -= i & -i {
+		keys = append(keys, keyDef{pk: zRankKey(key), sk: strconv.Itoa(i)}.toAV(c))
+	}
+
+	items, err := c.batchGetItems(keys)
+
+	for _, item := range items {
+		count += ReturnValue{item[vk]}.Int()
+	}
+
+	return count, err
+}
+
+// zRankCountLE returns the number of members with a score less than or equal to the given one.
+func (c Client) zRankCountLE(key string, score float64) (count int64, err error) {
+	if math.IsInf(score, -1) {
+		return 0, nil
+	}
+
+	if math.IsInf(score, +1) {
+		return c.zRankPrefix(key, c.rankIndex.buckets)
+	}
+
+	b := c.rankIndex.bucket(score)
+
+	count, err = c.zRankPrefix(key, b-1)
+	if err != nil {
+		return count, err
+	}
+
+	inBucket, err := c.zGeneralCount(key, zScore{c.rankIndex.low(b)}, zScore{score}, c.sortKeyNum)
+
+	return count + int64(inBucket), err
+}
+
+// zRankedRank implements ZRANK and ZREVRANK with the rank index. Members with the same score are ranked
+// lexicographically, like in the ordered range APIs.
+func (c Client) zRankedRank(key string, member string, forward bool) (rank int32, ok bool, err error) {
+	score, ok, err := c.ZSCORE(key, member)
+	if err != nil || !ok {
+		return
+	}
+
+	atOrBelow, err := c.zRankCountLE(key, score)
+	if err != nil {
+		return rank, false, err
+	}
+
+	ties, err := c.zGeneralRangeOrdered(key, zScore{score}, zScore{score}, 0, 0, true, c.sortKeyNum)
+	if err != nil {
+		return rank, false, err
+	}
+
+	position := atOrBelow - int64(len(ties))
+
+	for _, tie := range ties {
+		if tie.Member < member {
+			position++
+		}
+	}
+
+	if forward {
+		return int32(position), true, nil
+	}
+
+	total, err := c.zRankPrefix(key, c.rankIndex.buckets)
+
+	return int32(total - 1 - position), err == nil, err
+}
+
+// zRankedCount implements ZCOUNT with the rank index.
+func (c Client) zRankedCount(key string, min, max float64) (count int32, err error) {
+	if min > max {
+		return 0, nil
+	}
+
+	upTo, err := c.zRankCountLE(key, max)
+	if err != nil {
+		return count, err
+	}
+
+	below, err := c.zRankCountLE(key, min)
+	if err != nil || math.IsInf(min, 0) {
+		return int32(upTo - below), err
+	}
+
+	atMin, err := c.zGeneralCount(key, zScore{min}, zScore{min}, c.sortKeyNum)
+
+	return int32(upTo - below + int64(atMin)), err
+}
+
+// zRankedChange describes the outcome of zRankedWrite.
+type zRankedChange struct {
+	old     float64
+	existed bool
+	written bool
+	score   float64
+}
+
+// zRankedWrite changes a member of a sorted set with a rank index, keeping the index in sync. update is called
+// with the current score of the member (if it exists) and returns the new score, whether the member should be
+// removed instead, and whether anything should be written at all. The member and the affected tree nodes are
+// written in a single transaction, on the condition that the member hasn't changed since it was read.
+func (c Client) zRankedWrite(key string, member string,
+	update func(old float64, existed bool) (score float64, remove bool, write bool)) (change zRankedChange, err error) {
+	for retryCount := 0; retryCount < zRankContentionRetries; retryCount++ {
+		resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+			ConsistentRead: aws.Bool(true),
+			Key:            keyDef{pk: key, sk: member}.toAV(c),
+			TableName:      aws.String(c.tableName),
+		})
+		if err != nil {
+			return change, err
+		}
+
+		change = zRankedChange{existed: len(resp.Item) > 0}
+		if change.existed {
+			change.old = zScoreFromAV(resp.Item[c.sortKeyNum])
+		}
+
+		score, remove, write := update(change.old, change.existed)
+		if !write || (remove && !change.existed) {
+			change.score = change.old
+			return change, nil
+		}
+
+		builder := newExpresionBuilder()
+
+		if change.existed {
+			builder.addConditionEquality(c.sortKeyNum, zScore{change.old})
+		} else {
+			builder.addConditionNotExists(c.partitionKey)
+		}
+
+		bucketChanges := make(map[int]int64)
+		memberAction := types.TransactWriteItem{}
+
+		if remove {
+			memberAction.Delete = &types.Delete{
+				ConditionExpression:       builder.conditionExpression(),
+				ExpressionAttributeNames:  builder.expressionAttributeNames(),
+				ExpressionAttributeValues: builder.expressionAttributeValues(),
+				Key:                       keyDef{pk: key, sk: member}.toAV(c),
+				TableName:                 aws.String(c.tableName),
+			}
+		} else {
+			item := keyDef{pk: key, sk: member}.toAV(c)
+			item[c.sortKeyNum] = zScore{score}.ToAV()
+
+			memberAction.Put = &types.Put{
+				ConditionExpression:       builder.conditionExpression(),
+				ExpressionAttributeNames:  builder.expressionAttributeNames(),
+				ExpressionAttributeValues: builder.expressionAttributeValues(),
+				Item:                      item,
+				TableName:                 aws.String(c.tableName),
+			}
+
+			bucketChanges[c.rankIndex.bucket(score)]++
+		}
+
+		if change.existed {
+			bucketChanges[c.rankIndex.bucket(change.old)]--
+		}
+
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]types.TransactWriteItem{memberAction}, c.zRankNodeActions(key, bucketChanges)...),
+		})
+
+		if conditionFailureError(err) {
+			continue
+		}
+
+		if err != nil {
+			return change, err
+		}
+
+		change.written, change.score = true, score
+
+		return change, nil
+	}
+
+	return change, errors.New("too much contention")
+}
+
+// ZRANKINDEXREBUILD rebuilds the rank index of the sorted set at key from its members, for example after the
+// set was written to by a client without a rank index, or after its members were changed with DEL. The
+// rebuild isn't atomic, so writes to the set should be paused while it runs.
+//
+// Cost is O(N) to read the set, plus O(buckets) WCUs to write the index.
+func (c Client) ZRANKINDEXREBUILD(key string) (err error) {
+	if c.rankIndex == nil {
+		return errors.New("the client has no rank index configured")
+	}
+
+	members, err := c.zGeneralRangeOrdered(key, negInf, posInf, 0, 0, true, c.sortKeyNum)
+	if err != nil {
+		return err
+	}
+
+	tree := make([]int64, c.rankIndex.buckets+1)
+
+	for _, m := range members {
+		for i := c.rankIndex.bucket(m.Score); i <= c.rankIndex.buckets; i += i & -i {
+			tree[i]++
+		}
+	}
+
+	if _, err = c.delPartition(zRankKey(key)); err != nil {
+		return err
+	}
+
+	var requests []types.WriteRequest
+
+	for node, count := range tree {
+		if count == 0 {
+			continue
+		}
+
+		item := keyDef{pk: zRankKey(key), sk: strconv.Itoa(node)}.toAV(c)
+		item[vk] = IntValue{count}.ToAV()
+
+		requests = append(requests, c.putRequest(item))
+	}
+
+	return c.batchWriteItems(requests)
+}
+
+// zFlagsAllow checks the NX, XX, GT and LT flags of ZADD against the current state of a member.
+func zFlagsAllow(flags Flags, old float64, existed bool, score float64) bool {
+	switch {
+	case flags.has(IfNotExists) && existed:
+		return false
+	case flags.has(IfAlreadyExists) && !existed:
+		return false
+	case flags.has(IfGreaterThan) && existed && !(score > old):
+		return false
+	case flags.has(IfLessThan) && existed && !(score < old):
+		return false
+	}
+
+	return true
+}
+
+func (c Client) zRankedAdd(key string, membersWithScores map[string]float64, flags Flags) (addedMembers []string, err error) {
+	for member, score := range membersWithScores {
+		score := score
+
+		change, err := c.zRankedWrite(key, member, func(old float64, existed bool) (float64, bool, bool) {
+			return score, false, zFlagsAllow(flags, old, existed, score)
+		})
+		if err != nil {
+			return addedMembers, err
+		}
+
+		switch {
+		case change.written && !change.existed:
+			addedMembers = append(addedMembers, member)
+		case change.written && flags.has(ReturnChanged) && change.old != change.score:
+			addedMembers = append(addedMembers, member)
+		}
+	}
+
+	return
+}
+
+func (c Client) zRankedIncr(key string, member string, delta float64, flags Flags) (newScore float64, ok bool, err error) {
+	change, err := c.zRankedWrite(key, member, func(old float64, existed bool) (float64, bool, bool) {
+		return old + delta, false, zFlagsAllow(flags, old, existed, old+delta)
+	})
+
+	return change.score, change.written, err
+}
+
+func (c Client) zRankedRem(key string, members ...string) (removedMembers []string, err error) {
+	for _, member := range members {
+		change, err := c.zRankedWrite(key, member, func(old float64, existed bool) (float64, bool, bool) {
+			return old, true, true
+		})
+		if err != nil {
+			return removedMembers, err
+		}
+
+		if change.written {
+			removedMembers = append(removedMembers, member)
+		}
+	}
+
+	return
+}
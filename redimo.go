@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -21,6 +22,9 @@ type Client struct {
 	sortKey            string
 	sortKeyNum         string
 	transactionActions int
+	batchConcurrency   int
+	notifier           *PushNotifier
+	rankIndex          *zRankIndex
 }
 
 func (c Client) EventuallyConsistent() Client {
@@ -56,6 +60,39 @@ func (c Client) TransactionActions(actions int) Client {
 	return c
 }
 
+// BatchConcurrency sets how many BatchWriteItem requests the batch operations (like SADDBATCH) keep
+// in flight at the same time.
+func (c Client) BatchConcurrency(requests int) Client {
+	c.batchConcurrency = requests
+	return c
+}
+
+// RankIndex makes the client maintain a rank index for the sorted sets it writes to, and use it for ZRANK,
+// ZREVRANK and ZCOUNT. The index splits the scores between min and max into the given number of equal
+// buckets (scores outside the range go into the first or last bucket) and keeps the number of members per
+// bucket in a Fenwick tree stored in a side hash, so a rank needs O(log buckets) reads plus a count of the
+// members in a single bucket, instead of counting every member below the score.
+//
+// Every write to a sorted set then reads the member first and updates the index in the same transaction,
+// which costs O(log buckets) extra WCUs. All writes to an indexed sorted set must go through clients with
+// the same rank index configuration, otherwise the index must be rebuilt with ZRANKINDEXREBUILD.
+func (c Client) RankIndex(min, max float64, buckets int) Client {
+	if buckets < 1 {
+		buckets = 1
+	}
+
+	c.rankIndex = &zRankIndex{min: min, max: max, buckets: buckets}
+
+	return c
+}
+
+// Notifier attaches a PushNotifier to the client, so that blocking operations like BLPOP wake up
+// immediately when elements are pushed through a client sharing the same notifier.
+func (c Client) Notifier(notifier *PushNotifier) Client {
+	c.notifier = notifier
+	return c
+}
+
 func (c Client) ExistsTable() (bool, error) {
 	_, err := c.ddbClient.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{
 		TableName: aws.String(c.tableName),
@@ -164,6 +201,7 @@ func NewClient(service *dynamodb.Client) Client {
 		sortKey:            "sk",
 		sortKeyNum:         "skN",
 		transactionActions: 100,
+		batchConcurrency:   8,
 	}
 }
 
@@ -348,6 +386,9 @@ const (
 	Unconditionally      = None
 	IfAlreadyExists Flag = "XX"
 	IfNotExists     Flag = "NX"
+	IfGreaterThan   Flag = "GT"
+	IfLessThan      Flag = "LT"
+	ReturnChanged   Flag = "CH"
 )
 
 type Flags []Flag
@@ -386,3 +427,134 @@ func conditionFailureError(err error) bool {
 
 	return false
 }
+
+// throttlingError reports whether err means the request was throttled, so that it can succeed if retried later.
+func throttlingError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	s := err.Error()
+
+	for _, code := range []string{"ProvisionedThroughputExceededException", "RequestLimitExceeded", "ThrottlingException", "ThrottlingError"} {
+		if strings.Contains(s, code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transactionConditionFailures returns the positions of the actions whose conditions failed, if err is a
+// cancelled transaction.
+func transactionConditionFailures(err error) (failed []int) {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		return nil
+	}
+
+	for i, reason := range cancelled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			failed = append(failed, i)
+		}
+	}
+
+	return failed
+}
+
+// globMatch reports whether s matches the glob-style pattern, following the same rules as the MATCH option of
+// Redis' SCAN family: * matches any sequence of characters, ? matches a single character, [abc], [^abc] and
+// [a-z] match character classes and \ escapes the character that follows it.
+func globMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+			_, size := utf8.DecodeRuneInString(s)
+			s, pattern = s[size:], pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			r, size := utf8.DecodeRuneInString(s)
+			pattern = pattern[1:]
+
+			negate := len(pattern) > 0 && pattern[0] == '^'
+			if negate {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+				}
+
+				low, n := utf8.DecodeRuneInString(pattern)
+				pattern = pattern[n:]
+				high := low
+
+				if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+					high, n = utf8.DecodeRuneInString(pattern[1:])
+					pattern = pattern[1+n:]
+
+					if low > high {
+						low, high = high, low
+					}
+				}
+
+				matched = matched || (r >= low && r <= high)
+			}
+
+			if len(pattern) > 0 {
+				pattern = pattern[1:]
+			}
+
+			if matched == negate {
+				return false
+			}
+
+			s = s[size:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			if len(s) == 0 {
+				return false
+			}
+
+			pr, pn := utf8.DecodeRuneInString(pattern)
+			sr, sn := utf8.DecodeRuneInString(s)
+
+			if pr != sr {
+				return false
+			}
+
+			s, pattern = s[sn:], pattern[pn:]
+		}
+	}
+
+	return len(s) == 0
+}
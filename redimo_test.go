@@ -101,3 +101,20 @@ func newConfig(t *testing.T) aws.Config {
 
 	return sdkConfig
 }
+
+func TestGlobMatch(t *testing.T) {
+	assert.True(t, globMatch("*", ""))
+	assert.True(t, globMatch("*", "anything"))
+	assert.True(t, globMatch("h?llo", "hello"))
+	assert.False(t, globMatch("h?llo", "hllo"))
+	assert.True(t, globMatch("h*llo", "heeeello"))
+	assert.True(t, globMatch("h[ae]llo", "hallo"))
+	assert.False(t, globMatch("h[ae]llo", "hillo"))
+	assert.True(t, globMatch("h[^e]llo", "hallo"))
+	assert.False(t, globMatch("h[^e]llo", "hello"))
+	assert.True(t, globMatch("h[a-b]llo", "hbllo"))
+	assert.True(t, globMatch("user:*/profile", "user:42/profile"))
+	assert.True(t, globMatch(`h\*llo`, "h*llo"))
+	assert.False(t, globMatch(`h\*llo`, "hello"))
+	assert.False(t, globMatch("m?", "m"))
+}
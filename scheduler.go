@@ -0,0 +1,376 @@
+package redimo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const schedulerDueKey = "due"
+
+// schedulerContentionRetries is how many rounds in a row Due reads the due jobs again without claiming any of
+// them before it returns.
+const schedulerContentionRetries = 5
+
+// Scheduler runs jobs at a given time, using a redimo sorted set as the schedule: the members are job IDs and the
+// scores are the times the jobs are due, in milliseconds since the epoch. The job payloads are stored on the
+// members themselves.
+//
+// Workers poll the schedule with Due, which claims the jobs that are due by moving them to a processing set,
+// where they hold a lease until they are marked done with Complete. Claims are conditional on the job still
+// being due at the time it was read, so any number of workers on any number of hosts can share a schedule
+// without running a job twice. If a lease expires before the job is completed, Reap puts the job back on the
+// schedule so another worker can pick it up.
+//
+// The schedule is a regular sorted set, so it can be inspected with ZRANGE, ZSCORE, ZCARD, etc. If the client has
+// a rank index (see Client.RankIndex), every change to the schedule updates the index as well.
+type Scheduler struct {
+	c     Client
+	name  string
+	lease time.Duration
+}
+
+// ScheduledJob is a job claimed from a Scheduler.
+type ScheduledJob struct {
+	ID           string
+	Payload      ReturnValue
+	Due          time.Time
+	LeaseExpires time.Time
+}
+
+// NewScheduler creates a scheduler that keeps its schedule in the sorted set at the given key. Claimed jobs
+// are leased for a minute by default, use Lease to change that.
+func NewScheduler(c Client, name string) Scheduler {
+	return Scheduler{
+		c:     c,
+		name:  name,
+		lease: time.Minute,
+	}
+}
+
+// Lease sets how long a worker has to complete a job claimed with Due before it is put back on the schedule by Reap.
+func (s Scheduler) Lease(lease time.Duration) Scheduler {
+	s.lease = lease
+	return s
+}
+
+func (s Scheduler) processingKey() string {
+	return strings.Join([]string{"_redimo", "scheduler", s.name, "processing"}, "/")
+}
+
+// Schedule adds a job with the given ID and payload to the schedule, to run at the given time. If a job with
+// the same ID is already scheduled, nothing is changed and ok will be false.
+//
+// Cost is O(1) / 1 WCU, plus the rank index updates if the client has a rank index.
+func (s Scheduler) Schedule(id string, payload string, at time.Time) (ok bool, err error) {
+	item := keyDef{pk: s.name, sk: id}.toAV(s.c)
+	item[s.c.sortKeyNum] = IntValue{timeToMillis(at)}.ToAV()
+	item[vk] = StringValue{payload}.ToAV()
+
+	builder := newExpresionBuilder()
+	builder.addConditionNotExists(s.c.partitionKey)
+
+	if rankActions := s.c.zRankScoreActions(s.name, nil, []float64{float64(timeToMillis(at))}); len(rankActions) > 0 {
+		_, err = s.c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]types.TransactWriteItem{
+				{
+					Put: &types.Put{
+						ConditionExpression:       builder.conditionExpression(),
+						ExpressionAttributeNames:  builder.expressionAttributeNames(),
+						ExpressionAttributeValues: builder.expressionAttributeValues(),
+						Item:                      item,
+						TableName:                 aws.String(s.c.tableName),
+					},
+				},
+			}, rankActions...),
+		})
+	} else {
+		_, err = s.c.ddbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+			ConditionExpression:       builder.conditionExpression(),
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			Item:                      item,
+			TableName:                 aws.String(s.c.tableName),
+		})
+	}
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Reschedule changes the time a scheduled job runs at. If the job isn't on the schedule (because it was never
+// scheduled, was cancelled or has been claimed), ok will be false.
+//
+// Cost is O(1) / 1 WCU. If the client has a rank index, the current time of the job is read first so the index
+// can be updated in the same transaction.
+func (s Scheduler) Reschedule(id string, at time.Time) (ok bool, err error) {
+	if s.c.rankIndex != nil {
+		return s.rescheduleRanked(id, at)
+	}
+
+	builder := newExpresionBuilder()
+	builder.updateSetAV(s.c.sortKeyNum, IntValue{timeToMillis(at)}.ToAV())
+	builder.addConditionExists(s.c.partitionKey)
+
+	_, err = s.c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: s.name, sk: id}.toAV(s.c),
+		TableName:                 aws.String(s.c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// rescheduleRanked is Reschedule for a client with a rank index: the job's time is only changed if it's still
+// the one that was read, so the index moves the job out of the right bucket.
+func (s Scheduler) rescheduleRanked(id string, at time.Time) (ok bool, err error) {
+	for retryCount := 0; retryCount < zRankContentionRetries; retryCount++ {
+		resp, err := s.c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+			ConsistentRead: aws.Bool(true),
+			Key:            keyDef{pk: s.name, sk: id}.toAV(s.c),
+			TableName:      aws.String(s.c.tableName),
+		})
+		if err != nil || len(resp.Item) == 0 {
+			return false, err
+		}
+
+		old := zScoreFromAV(resp.Item[s.c.sortKeyNum])
+
+		builder := newExpresionBuilder()
+		builder.updateSetAV(s.c.sortKeyNum, IntValue{timeToMillis(at)}.ToAV())
+		builder.addConditionEquality(s.c.sortKeyNum, IntValue{int64(old)})
+
+		_, err = s.c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]types.TransactWriteItem{
+				{
+					Update: &types.Update{
+						ConditionExpression:       builder.conditionExpression(),
+						ExpressionAttributeNames:  builder.expressionAttributeNames(),
+						ExpressionAttributeValues: builder.expressionAttributeValues(),
+						Key:                       keyDef{pk: s.name, sk: id}.toAV(s.c),
+						TableName:                 aws.String(s.c.tableName),
+						UpdateExpression:          builder.updateExpression(),
+					},
+				},
+			}, s.c.zRankScoreActions(s.name, []float64{old}, []float64{float64(timeToMillis(at))})...),
+		})
+
+		if conditionFailureError(err) {
+			// The job was rescheduled, claimed or cancelled since it was read, look again.
+			continue
+		}
+
+		return err == nil, err
+	}
+
+	return false, errors.New("too much contention")
+}
+
+// Cancel removes a job from the schedule. If the job isn't on the schedule, ok will be false; a job that has
+// already been claimed can't be cancelled.
+//
+// Cost is O(1) / 1 WCU.
+func (s Scheduler) Cancel(id string) (ok bool, err error) {
+	removed, err := s.c.ZREM(s.name, id)
+	return len(removed) > 0, err
+}
+
+// Due claims up to n jobs that are due at the given time, earliest first, and leases them to the caller. The
+// jobs are found with a range query on the index, and each one is moved to the processing set in a transaction
+// that only succeeds if it's still scheduled for the same time, so a job is never claimed twice. Jobs claimed by
+// other workers in the meantime are skipped, and replaced by reading the due jobs again; after
+// schedulerContentionRetries rounds in a row without claiming anything, the jobs claimed so far are returned.
+//
+// Cost is O(n) / 2 WCUs for each claimed job, plus a range query on the index.
+func (s Scheduler) Due(now time.Time, n int32) (jobs []ScheduledJob, err error) {
+	for retryCount := 0; int32(len(jobs)) < n && retryCount < schedulerContentionRetries; {
+		items, err := s.dueItems(now, n-int32(len(jobs)))
+		if err != nil || len(items) == 0 {
+			return jobs, err
+		}
+
+		claimed := len(jobs)
+
+		for _, item := range items {
+			job := ScheduledJob{
+				ID:           parseKey(item, s.c).sk,
+				Payload:      ReturnValue{item[vk]},
+				Due:          millisToTime(ReturnValue{item[s.c.sortKeyNum]}.Int()),
+				LeaseExpires: time.Now().Add(s.lease),
+			}
+
+			ok, err := s.claim(job)
+			if err != nil {
+				return jobs, err
+			}
+
+			if ok {
+				jobs = append(jobs, job)
+			}
+		}
+
+		if len(jobs) > claimed {
+			retryCount = 0
+		} else {
+			retryCount++
+		}
+	}
+
+	return jobs, nil
+}
+
+// dueItems reads up to limit jobs scheduled at or before now, earliest first.
+func (s Scheduler) dueItems(now time.Time, limit int32) (items []map[string]types.AttributeValue, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(s.c.partitionKey, StringValue{s.name})
+	builder.condition(fmt.Sprintf("#%v <= :now", s.c.sortKeyNum), s.c.sortKeyNum)
+	builder.values["now"] = IntValue{timeToMillis(now)}.ToAV()
+
+	resp, err := s.c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+		ConsistentRead:            aws.Bool(s.c.consistentReads),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		IndexName:                 aws.String(s.c.indexName),
+		KeyConditionExpression:    builder.conditionExpression(),
+		Limit:                     aws.Int32(limit),
+		ScanIndexForward:          aws.Bool(true),
+		Select:                    types.SelectAllAttributes,
+		TableName:                 aws.String(s.c.tableName),
+	})
+	if err != nil {
+		return
+	}
+
+	return resp.Items, nil
+}
+
+// claim moves a due job from the schedule to the processing set, if it's still scheduled for the same time.
+func (s Scheduler) claim(job ScheduledJob) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(s.c.sortKeyNum, IntValue{timeToMillis(job.Due)})
+
+	err = s.c.takeLease(keyDef{pk: s.name, sk: job.ID}, builder, job.processingItem(s),
+		s.c.zRankScoreActions(s.name, []float64{float64(timeToMillis(job.Due))}, nil)...)
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (j ScheduledJob) processingItem(s Scheduler) map[string]types.AttributeValue {
+	item := keyDef{pk: s.processingKey(), sk: j.ID}.toAV(s.c)
+	item[s.c.sortKeyNum] = IntValue{timeToMillis(j.LeaseExpires)}.ToAV()
+	item[vk] = j.Payload.ToAV()
+	item[schedulerDueKey] = IntValue{timeToMillis(j.Due)}.ToAV()
+
+	return item
+}
+
+func parseScheduledJob(item map[string]types.AttributeValue, c Client) ScheduledJob {
+	return ScheduledJob{
+		ID:           parseKey(item, c).sk,
+		Payload:      ReturnValue{item[vk]},
+		Due:          millisToTime(ReturnValue{item[schedulerDueKey]}.Int()),
+		LeaseExpires: millisToTime(ReturnValue{item[c.sortKeyNum]}.Int()),
+	}
+}
+
+// Complete marks a claimed job as done, removing it from the processing set. If the job is no longer leased,
+// because it was already completed or its lease expired and it was put back on the schedule, ok will be false.
+//
+// Cost is O(1) / 1 WCU.
+func (s Scheduler) Complete(id string) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionExists(s.c.partitionKey)
+
+	_, err = s.c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: s.processingKey(), sk: id}.toAV(s.c),
+		TableName:                 aws.String(s.c.tableName),
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Reap puts every claimed job whose lease has expired back on the schedule, at its original due time, so it
+// will be returned by the next call to Due. It reports how many jobs were put back. If a job with the same ID
+// was scheduled again in the meantime, the expired lease is dropped and the new job is left alone.
+//
+// Cost is O(N) where N is the number of expired leases, plus a range query on the index.
+func (s Scheduler) Reap() (requeued int, err error) {
+	err = s.c.expiredLeases(s.processingKey(), time.Now(), func(item map[string]types.AttributeValue) error {
+		ok, err := s.release(parseScheduledJob(item, s.c))
+		if ok {
+			requeued++
+		}
+
+		return err
+	})
+
+	return
+}
+
+// release moves a leased job back to the schedule. The processing item is only deleted if it still holds the
+// same lease, so a job that was completed concurrently is left alone, and the job is only put back if no job
+// with the same ID has been scheduled since it was claimed.
+func (s Scheduler) release(job ScheduledJob) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionNotExists(s.c.partitionKey)
+
+	item := keyDef{pk: s.name, sk: job.ID}.toAV(s.c)
+	item[s.c.sortKeyNum] = IntValue{timeToMillis(job.Due)}.ToAV()
+	item[vk] = job.Payload.ToAV()
+
+	err = s.c.releaseLease(s.processingKey(), job.ID, job.LeaseExpires, append([]types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				ConditionExpression:       builder.conditionExpression(),
+				ExpressionAttributeNames:  builder.expressionAttributeNames(),
+				ExpressionAttributeValues: builder.expressionAttributeValues(),
+				Item:                      item,
+				TableName:                 aws.String(s.c.tableName),
+			},
+		},
+	}, s.c.zRankScoreActions(s.name, nil, []float64{float64(timeToMillis(job.Due))})...)...)
+
+	for _, failed := range transactionConditionFailures(err) {
+		if failed == 1 {
+			// The ID is on the schedule again, so the expired lease is stale: drop it.
+			if err = s.c.releaseLease(s.processingKey(), job.ID, job.LeaseExpires); conditionFailureError(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+	}
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,136 @@
+package redimo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	c := newClient(t)
+	s := NewScheduler(c, "jobs").Lease(time.Millisecond)
+	now := time.Now()
+
+	ok, err := s.Schedule("first", "one", now.Add(-2*time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Schedule("second", "two", now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Schedule("later", "three", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Schedule("later", "duplicate", now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.Schedule("cancelled", "four", now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Cancel("cancelled")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	count, err := c.ZCARD("jobs")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), count)
+
+	jobs, err := s.Due(now, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "first", jobs[0].ID)
+	assert.Equal(t, "one", jobs[0].Payload.String())
+
+	ok, err = s.Complete("first")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Complete("first")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	jobs, err = s.Due(now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "second", jobs[0].ID)
+
+	jobs, err = s.Due(now, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	time.Sleep(10 * time.Millisecond)
+
+	requeued, err := s.Reap()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+
+	ok, err = s.Reschedule("later", now.Add(-time.Second))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = s.Reschedule("missing", now)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	jobs, err = s.Due(now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "second", jobs[0].ID)
+	assert.Equal(t, "two", jobs[0].Payload.String())
+	assert.Equal(t, "later", jobs[1].ID)
+	assert.Equal(t, "three", jobs[1].Payload.String())
+}
+
+func TestSchedulerRankIndex(t *testing.T) {
+	now := time.Now()
+	c := newClient(t).RankIndex(float64(timeToMillis(now.Add(-time.Hour))), float64(timeToMillis(now.Add(time.Hour))), 8)
+	s := NewScheduler(c, "jobs").Lease(time.Millisecond)
+
+	for i, id := range []string{"a", "b", "c", "d"} {
+		ok, err := s.Schedule(id, id, now.Add(time.Duration(i-2)*time.Minute))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ok, err := s.Reschedule("d", now.Add(-3*time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	rank, found, err := c.ZRANK("jobs", "d")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int32(0), rank)
+
+	jobs, err := s.Due(now, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+
+	count, err := c.ZCOUNT("jobs", math.Inf(-1), math.Inf(+1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), count)
+
+	time.Sleep(10 * time.Millisecond)
+
+	requeued, err := s.Reap()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requeued)
+
+	ok, err = s.Cancel("c")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	count, err = c.ZCOUNT("jobs", math.Inf(-1), math.Inf(+1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), count)
+
+	rank, found, err = c.ZRANK("jobs", "b")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int32(2), rank)
+}
@@ -2,6 +2,8 @@ package redimo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -55,6 +57,96 @@ func (c Client) SADD(key string, members ...string) (addedMembers []string, err
 	return
 }
 
+// SADDBATCH adds the given members to the set at key like SADD, but writes them with batched BatchWriteItem
+// calls, several of them in parallel (see Client.BatchConcurrency). This is much faster for large numbers of
+// members, but can't report which members were newly added; use SADDBATCHADDED when that's needed.
+//
+// Cost is O(N) / 1 WCU for each member, with one request for every 25 members.
+func (c Client) SADDBATCH(key string, members ...string) (err error) {
+	requests := make([]types.WriteRequest, 0, len(members))
+	for _, member := range uniqueStrings(members) {
+		requests = append(requests, c.putRequest(setMember{pk: key, sk: member}.toAV(c)))
+	}
+
+	return c.batchWriteItems(requests)
+}
+
+// SADDBATCHADDED adds the given members to the set at key like SADDBATCH, but reports the members that were
+// actually added, like SADD. Each member is written with a conditional put, in transactions of up to the
+// client's TransactionActions members; the members that already exist make the transaction fail, and are
+// left out when it's retried.
+//
+// Cost is O(N) / 2 WCUs for each member added, with one request for every TransactionActions members, plus a
+// retry of the request for each chunk that contains existing members.
+func (c Client) SADDBATCHADDED(key string, members ...string) (addedMembers []string, err error) {
+	pending := uniqueStrings(members)
+
+	for retryCount := 0; len(pending) > 0; {
+		chunk := pending
+		if len(chunk) > c.transactionActions {
+			chunk = chunk[:c.transactionActions]
+		}
+
+		actions := make([]types.TransactWriteItem, len(chunk))
+
+		for i, member := range chunk {
+			builder := newExpresionBuilder()
+			builder.addConditionNotExists(c.partitionKey)
+
+			actions[i] = types.TransactWriteItem{
+				Put: &types.Put{
+					ConditionExpression:      builder.conditionExpression(),
+					ExpressionAttributeNames: builder.expressionAttributeNames(),
+					Item:                     setMember{pk: key, sk: member}.toAV(c),
+					TableName:                aws.String(c.tableName),
+				},
+			}
+		}
+
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: actions,
+		})
+
+		if err == nil {
+			addedMembers = append(addedMembers, chunk...)
+			pending = pending[len(chunk):]
+			retryCount = 0
+
+			continue
+		}
+
+		existing := transactionConditionFailures(err)
+
+		if len(existing) == 0 {
+			if !conditionFailureError(err) {
+				return addedMembers, err
+			}
+
+			// conflicted with another transaction, try again.
+			if retryCount++; retryCount == setContentionRetries {
+				return addedMembers, errors.New("too much contention")
+			}
+
+			continue
+		}
+
+		remaining := make([]string, 0, len(pending)-len(existing))
+
+		for i, member := range chunk {
+			if len(existing) > 0 && existing[0] == i {
+				existing = existing[1:]
+				continue
+			}
+
+			remaining = append(remaining, member)
+		}
+
+		pending = append(remaining, pending[len(chunk):]...)
+	}
+
+	return addedMembers, nil
+}
+
 // SCARD returns the cardinality (the number of elements) in the set at key.
 //
 // Cost is O(size) / 1 WCU per 4KB of data counted.
@@ -94,15 +186,38 @@ func (c Client) SDIFF(key string, subtractKeys ...string) (members []string, err
 	return
 }
 
+// SDIFFSTORE stores the result of SDIFF in destinationKey, replacing its current members, and returns the
+// number of members in the result. The swap is atomic, so results too large for a single transaction fail
+// with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/sdiffstore
 func (c Client) SDIFFSTORE(destinationKey string, sourceKey string, subtractKeys ...string) (count int32, err error) {
 	members, err := c.SDIFF(sourceKey, subtractKeys...)
 	if err == nil {
-		_, err = c.SADD(destinationKey, members...)
+		err = c.sStore(destinationKey, members)
 	}
 
 	return int32(len(members)), err
 }
 
+// SDIFFEACH calls fn with every member of the set at key that isn't a member of any of the subtractKeys sets,
+// until fn returns false. Unlike SDIFF, the sets are never loaded into memory: the set at key is read a page
+// at a time, and each page is checked against the other sets with BatchGetItem.
+//
+// Cost is O(N * M) / 1 RCU for each member checked, where N is the size of the set at key and M the number of subtractKeys.
+func (c Client) SDIFFEACH(fn func(member string) (more bool), key string, subtractKeys ...string) (err error) {
+	return c.sEachPage(key, func(members []string) (more bool, err error) {
+		for _, otherKey := range subtractKeys {
+			members, err = c.sFilter(otherKey, members, false)
+			if err != nil || len(members) == 0 {
+				return err == nil, err
+			}
+		}
+
+		return c.sEmit(fn, members), nil
+	})
+}
+
 func (c Client) SINTER(key string, otherKeys ...string) (members []string, err error) {
 	memberSet := make(map[string]struct{})
 	startingList, err := c.SMEMBERS(key)
@@ -141,15 +256,54 @@ func (c Client) SINTER(key string, otherKeys ...string) (members []string, err e
 	return
 }
 
+// SINTERSTORE stores the result of SINTER in destinationKey, replacing its current members, and returns the
+// number of members in the result. The swap is atomic, so results too large for a single transaction fail
+// with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/sinterstore
 func (c Client) SINTERSTORE(destinationKey string, sourceKey string, otherKeys ...string) (count int32, err error) {
 	members, err := c.SINTER(sourceKey, otherKeys...)
 	if err == nil {
-		_, err = c.SADD(destinationKey, members...)
+		err = c.sStore(destinationKey, members)
 	}
 
 	return int32(len(members)), err
 }
 
+// SINTERCARD returns the number of members in the intersection of the given sets. If limit is positive, the
+// count stops at limit, and no more members are read once it is reached.
+//
+// Cost is O(N * M) / 1 RCU for each member checked, where N is the size of the first set and M the number of keys,
+// or less if the limit is reached early.
+//
+// Works similar to https://redis.io/commands/sintercard
+func (c Client) SINTERCARD(limit int32, key string, otherKeys ...string) (count int32, err error) {
+	err = c.SINTEREACH(func(member string) bool {
+		count++
+		return limit <= 0 || count < limit
+	}, key, otherKeys...)
+
+	return
+}
+
+// SINTEREACH calls fn with every member of the intersection of the given sets, until fn returns false. Unlike
+// SINTER, the sets are never loaded into memory: the set at key is read a page at a time, and each page is
+// checked against the other sets with BatchGetItem. Pass the smallest set as key for the best performance.
+//
+// Cost is O(N * M) / 1 RCU for each member checked, where N is the size of the set at key and M the number of otherKeys.
+func (c Client) SINTEREACH(fn func(member string) (more bool), key string, otherKeys ...string) (err error) {
+	return c.sEachPage(key, func(members []string) (more bool, err error) {
+		for _, otherKey := range otherKeys {
+			members, err = c.sFilter(otherKey, members, true)
+			if err != nil || len(members) == 0 {
+				return err == nil, err
+			}
+		}
+
+		return c.sEmit(fn, members), nil
+	})
+}
+
 func (c Client) SISMEMBER(key string, member string) (ok bool, err error) {
 	resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
 		ConsistentRead: aws.Bool(c.consistentReads),
@@ -163,6 +317,25 @@ func (c Client) SISMEMBER(key string, member string) (ok bool, err error) {
 	return true, nil
 }
 
+// SMISMEMBER reports whether each of the given members is a member of the set at key, in the same order as members.
+//
+// Cost is O(N) / 1 RCU for each member checked, with one BatchGetItem call for every 100 members.
+//
+// Works similar to https://redis.io/commands/smismember
+func (c Client) SMISMEMBER(key string, members ...string) (results []bool, err error) {
+	present, err := c.sPresent(key, members)
+	if err != nil {
+		return
+	}
+
+	results = make([]bool, len(members))
+	for i, member := range members {
+		_, results[i] = present[member]
+	}
+
+	return
+}
+
 func (c Client) SMEMBERS(key string) (members []string, err error) {
 	hasMoreResults := true
 
@@ -235,39 +408,201 @@ func (c Client) SMOVE(sourceKey string, destinationKey string, member string) (o
 	return true, nil
 }
 
+// setContentionRetries is how many times in a row SPOP reads new candidates without popping any of them (because
+// they were all popped by someone else) before giving up.
+const setContentionRetries = 5
+
+// setRandomRepeats is how many picks in a row SRANDMEMBER makes without finding a new member before it reads
+// the remaining members from the whole set.
+const setRandomRepeats = 5
+
+// SPOP removes and returns up to count random members from the set at key. Each member is removed with a
+// conditional delete, so a member is never returned by two concurrent calls to SPOP. A count of zero pops a
+// single member, like SPOP without a count.
+//
+// Cost is O(count) / 1 WCU for each member popped, plus the reads of SRANDMEMBER.
+//
+// Works similar to https://redis.io/commands/spop
 func (c Client) SPOP(key string, count int32) (members []string, err error) {
-	members, err = c.SRANDMEMBER(key, count)
-	if err == nil {
-		_, err = c.SREM(key, members...)
+	if count < 0 {
+		count = -count
+	}
+
+	if count == 0 {
+		count = 1
+	}
+
+	for retryCount := 0; int32(len(members)) < count; {
+		candidates, err := c.SRANDMEMBER(key, count-int32(len(members)))
+		if err != nil || len(candidates) == 0 {
+			return members, err
+		}
+
+		popped := len(members)
+
+		for _, member := range candidates {
+			builder := newExpresionBuilder()
+			builder.addConditionExists(c.partitionKey)
+
+			_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+				ConditionExpression:      builder.conditionExpression(),
+				ExpressionAttributeNames: builder.expressionAttributeNames(),
+				Key:                      setMember{pk: key, sk: member}.keyAV(c),
+				TableName:                aws.String(c.tableName),
+			})
+
+			if conditionFailureError(err) {
+				// popped by someone else, pick another one.
+				continue
+			}
+
+			if err != nil {
+				return members, err
+			}
+
+			members = append(members, member)
+		}
+
+		if len(members) > popped {
+			retryCount = 0
+		} else if retryCount++; retryCount == setContentionRetries {
+			return members, errors.New("too much contention")
+		}
 	}
 
 	return
 }
 
+// SRANDMEMBER returns random members from the set at key. Every member is stored with a random number in
+// the index, so the members are found by seeking to a random point on the index and wrapping around.
+//
+// Each member is picked with its own random seek. With a negative count, -count members are returned and the
+// same member can be returned more than once. With a positive count, up to count distinct members are
+// returned: repeated picks are discarded, and once several picks in a row only find members that were already
+// picked, the set is considered exhausted and the rest are picked from a full read of the set instead.
+//
+// Cost is O(count) / 1 RCU per member picked, or O(N) where N is the number of members in the set if count is
+// close to N.
+//
+// Works similar to https://redis.io/commands/srandmember
 func (c Client) SRANDMEMBER(key string, count int32) (members []string, err error) {
 	if count < 0 {
-		count = -count
+		for i := int32(0); i < -count; i++ {
+			seeked, err := c.sSeekRandom(key, 1)
+			if err != nil || len(seeked) == 0 {
+				return members, err
+			}
+
+			members = append(members, seeked...)
+		}
+
+		return members, nil
 	}
 
-	builder := newExpresionBuilder()
-	builder.addConditionEquality(c.partitionKey, StringValue{key})
+	picked := make(map[string]struct{}, count)
 
-	resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
-		ConsistentRead:            aws.Bool(c.consistentReads),
-		ExpressionAttributeNames:  builder.expressionAttributeNames(),
-		ExpressionAttributeValues: builder.expressionAttributeValues(),
-		KeyConditionExpression:    builder.conditionExpression(),
-		Limit:                     aws.Int32(count),
-		TableName:                 aws.String(c.tableName),
-	})
+	for repeats := 0; int32(len(members)) < count; {
+		seeked, err := c.sSeekRandom(key, 1)
+		if err != nil || len(seeked) == 0 {
+			return members, err
+		}
+
+		if _, ok := picked[seeked[0]]; !ok {
+			picked[seeked[0]] = struct{}{}
+			members = append(members, seeked[0])
+			repeats = 0
+
+			continue
+		}
 
+		if repeats++; repeats == setRandomRepeats {
+			return c.sRandomFill(key, members, picked, count)
+		}
+	}
+
+	return members, nil
+}
+
+// sRandomFill adds members of the set at key that are not yet picked to members, in random order, until
+// there are count of them or the set runs out.
+func (c Client) sRandomFill(key string, members []string, picked map[string]struct{}, count int32) ([]string, error) {
+	all, err := c.SMEMBERS(key)
 	if err != nil {
 		return members, err
 	}
 
-	for _, item := range resp.Items {
-		parsedItem := parseItem(item, c)
-		members = append(members, parsedItem.sk)
+	rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+
+	for _, member := range all {
+		if int32(len(members)) == count {
+			break
+		}
+
+		if _, ok := picked[member]; !ok {
+			members = append(members, member)
+		}
+	}
+
+	return members, nil
+}
+
+// sSeekRandom returns up to limit members following a random point on the index, wrapping around to the
+// start of the index if there aren't enough members after the point.
+func (c Client) sSeekRandom(key string, limit int32) (members []string, err error) {
+	point := rand.Int63()
+
+	members, err = c.sSeek(key, point, true, limit)
+	if err != nil || int32(len(members)) == limit {
+		return members, err
+	}
+
+	wrapped, err := c.sSeek(key, point, false, limit-int32(len(members)))
+
+	return append(members, wrapped...), err
+}
+
+// sSeek returns up to limit members, in index order, either from the given point onwards or before it.
+func (c Client) sSeek(key string, point int64, after bool, limit int32) (members []string, err error) {
+	hasMoreResults := true
+
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for hasMoreResults {
+		builder := newExpresionBuilder()
+		builder.addConditionEquality(c.partitionKey, StringValue{key})
+		builder.values["point"] = IntValue{point}.ToAV()
+
+		if after {
+			builder.condition(fmt.Sprintf("#%v >= :point", c.sortKeyNum), c.sortKeyNum)
+		} else {
+			builder.condition(fmt.Sprintf("#%v < :point", c.sortKeyNum), c.sortKeyNum)
+		}
+
+		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+			ConsistentRead:            aws.Bool(c.consistentReads),
+			ExclusiveStartKey:         lastEvaluatedKey,
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			IndexName:                 aws.String(c.indexName),
+			KeyConditionExpression:    builder.conditionExpression(),
+			Limit:                     aws.Int32(limit - int32(len(members))),
+			TableName:                 aws.String(c.tableName),
+		})
+
+		if err != nil {
+			return members, err
+		}
+
+		for _, item := range resp.Items {
+			parsedItem := parseItem(item, c)
+			members = append(members, parsedItem.sk)
+		}
+
+		if len(resp.LastEvaluatedKey) > 0 && int32(len(members)) < limit {
+			lastEvaluatedKey = resp.LastEvaluatedKey
+		} else {
+			hasMoreResults = false
+		}
 	}
 
 	return
@@ -295,6 +630,73 @@ func (c Client) SREM(key string, members ...string) (removedMembers []string, er
 	return
 }
 
+// SREMBATCH removes the given members from the set at key like SREM, but with batched BatchWriteItem calls.
+// It can't report which members were actually removed; use SREM when that's needed.
+//
+// Cost is O(N) / 1 WCU for each member, with one request for every 25 members.
+func (c Client) SREMBATCH(key string, members ...string) (err error) {
+	requests := make([]types.WriteRequest, 0, len(members))
+	for _, member := range uniqueStrings(members) {
+		requests = append(requests, c.deleteRequest(setMember{pk: key, sk: member}.keyAV(c)))
+	}
+
+	return c.batchWriteItems(requests)
+}
+
+// SSCAN iterates over the members of the set at key. Start with an empty cursor, and keep calling SSCAN with the
+// returned cursor until it's empty again. Each call reads up to count members (all remaining members if count is
+// zero) and returns the ones that match the glob-style pattern in match (every member if match is empty), so a
+// call can return fewer than count members, or none at all, before the iteration is done.
+//
+// Cost is O(count) / 1 RCU per 4KB of data read.
+//
+// Works similar to https://redis.io/commands/sscan
+func (c Client) SSCAN(key string, cursor string, match string, count int32) (nextCursor string, members []string, err error) {
+	var startKey map[string]types.AttributeValue
+
+	if cursor != "" {
+		startKey = map[string]types.AttributeValue{
+			c.partitionKey: StringValue{key}.ToAV(),
+			c.sortKey:      StringValue{cursor}.ToAV(),
+		}
+	}
+
+	var limit *int32
+	if count > 0 {
+		limit = aws.Int32(count)
+	}
+
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(c.partitionKey, StringValue{key})
+
+	resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+		ConsistentRead:            aws.Bool(c.consistentReads),
+		ExclusiveStartKey:         startKey,
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		KeyConditionExpression:    builder.conditionExpression(),
+		Limit:                     limit,
+		TableName:                 aws.String(c.tableName),
+	})
+
+	if err != nil {
+		return cursor, members, err
+	}
+
+	for _, item := range resp.Items {
+		member := parseItem(item, c).sk
+		if match == "" || globMatch(match, member) {
+			members = append(members, member)
+		}
+	}
+
+	if len(resp.LastEvaluatedKey) > 0 {
+		nextCursor = ReturnValue{resp.LastEvaluatedKey[c.sortKey]}.String()
+	}
+
+	return nextCursor, members, nil
+}
+
 func (c Client) SUNION(keys ...string) (members []string, err error) {
 	memberSet := make(map[string]struct{})
 
@@ -316,11 +718,153 @@ func (c Client) SUNION(keys ...string) (members []string, err error) {
 	return
 }
 
+// SUNIONSTORE stores the result of SUNION in destinationKey, replacing its current members, and returns the
+// number of members in the result. The swap is atomic, so results too large for a single transaction fail
+// with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/sunionstore
 func (c Client) SUNIONSTORE(destinationKey string, sourceKeys ...string) (count int32, err error) {
 	members, err := c.SUNION(sourceKeys...)
 	if err == nil {
-		_, err = c.SADD(destinationKey, members...)
+		err = c.sStore(destinationKey, members)
 	}
 
 	return int32(len(members)), err
 }
+
+// sStore replaces the members of the set at key with the given members.
+func (c Client) sStore(key string, members []string) (err error) {
+	items := make(map[string]map[string]types.AttributeValue, len(members))
+	for _, member := range members {
+		items[member] = setMember{pk: key, sk: member}.toAV(c)
+	}
+
+	return c.replaceItems(key, items)
+}
+
+// SUNIONEACH calls fn with every member of the union of the given sets, until fn returns false. Unlike SUNION,
+// the sets are never loaded into memory: each set is read a page at a time, and a member is only passed to fn
+// if it isn't a member of any of the sets before it, which is checked with BatchGetItem.
+//
+// Cost is O(N * M) / 1 RCU for each member checked, where N is the total size of the sets and M the number of keys.
+func (c Client) SUNIONEACH(fn func(member string) (more bool), keys ...string) (err error) {
+	for i, key := range keys {
+		more := true
+
+		err = c.sEachPage(key, func(members []string) (bool, error) {
+			var err error
+
+			for _, previousKey := range keys[:i] {
+				members, err = c.sFilter(previousKey, members, false)
+				if err != nil || len(members) == 0 {
+					return err == nil, err
+				}
+			}
+
+			more = c.sEmit(fn, members)
+
+			return more, nil
+		})
+
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sEachPage reads the members of the set at key one page at a time, until fn returns false or an error.
+func (c Client) sEachPage(key string, fn func(members []string) (more bool, err error)) (err error) {
+	hasMoreResults := true
+
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for hasMoreResults {
+		builder := newExpresionBuilder()
+		builder.addConditionEquality(c.partitionKey, StringValue{key})
+
+		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+			ConsistentRead:            aws.Bool(c.consistentReads),
+			ExclusiveStartKey:         lastEvaluatedKey,
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			KeyConditionExpression:    builder.conditionExpression(),
+			Limit:                     aws.Int32(batchGetSize),
+			TableName:                 aws.String(c.tableName),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		members := make([]string, 0, len(resp.Items))
+		for _, item := range resp.Items {
+			members = append(members, parseItem(item, c).sk)
+		}
+
+		more, err := fn(members)
+		if err != nil {
+			return err
+		}
+
+		if len(resp.LastEvaluatedKey) > 0 && more {
+			lastEvaluatedKey = resp.LastEvaluatedKey
+		} else {
+			hasMoreResults = false
+		}
+	}
+
+	return nil
+}
+
+// sEmit passes members to fn until it returns false, and reports whether fn wants more.
+func (c Client) sEmit(fn func(member string) (more bool), members []string) (more bool) {
+	for _, member := range members {
+		if !fn(member) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sFilter returns the given members that are (if keep is true) or aren't (if keep is false) members of the set at key.
+func (c Client) sFilter(key string, members []string, keep bool) (filtered []string, err error) {
+	present, err := c.sPresent(key, members)
+	if err != nil {
+		return
+	}
+
+	for _, member := range members {
+		if _, ok := present[member]; ok == keep {
+			filtered = append(filtered, member)
+		}
+	}
+
+	return
+}
+
+// sPresent returns which of the given members are members of the set at key.
+func (c Client) sPresent(key string, members []string) (present map[string]struct{}, err error) {
+	present = make(map[string]struct{})
+	seen := make(map[string]struct{})
+	keys := make([]map[string]types.AttributeValue, 0, len(members))
+
+	for _, member := range members {
+		if _, ok := seen[member]; ok {
+			continue
+		}
+
+		seen[member] = struct{}{}
+		keys = append(keys, setMember{pk: key, sk: member}.keyAV(c))
+	}
+
+	items, err := c.batchGetItems(keys)
+
+	for _, item := range items {
+		present[parseItem(item, c).sk] = struct{}{}
+	}
+
+	return present, err
+}
@@ -1,6 +1,8 @@
 package redimo
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -177,3 +179,202 @@ func TestSetModifiers(t *testing.T) {
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, []string{"m1"}, members)
 }
+
+func TestSetRandomMembers(t *testing.T) {
+	c := newClient(t)
+
+	var all []string
+	for i := 0; i < 20; i++ {
+		all = append(all, fmt.Sprintf("m%v", i))
+	}
+
+	_, err := c.SADD("s1", all...)
+	assert.NoError(t, err)
+
+	members, err := c.SRANDMEMBER("s1", 30)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, all, members)
+
+	members, err = c.SRANDMEMBER("s1", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(members))
+	assert.ElementsMatch(t, uniqueStrings(members), members)
+	assert.Subset(t, all, members)
+
+	seen := make(map[string]struct{})
+
+	for i := 0; i < 20; i++ {
+		members, err = c.SRANDMEMBER("s1", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(members))
+
+		seen[members[0]] = struct{}{}
+	}
+
+	assert.Greater(t, len(seen), 1)
+
+	_, err = c.SADD("s2", "only")
+	assert.NoError(t, err)
+
+	members, err = c.SRANDMEMBER("s2", -3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"only", "only", "only"}, members)
+
+	members, err = c.SPOP("s2", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"only"}, members)
+
+	members, err = c.SPOP("s1", 25)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, all, members)
+
+	count, err := c.SCARD("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), count)
+}
+
+func TestSetScansAndStreams(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.SADD("s1", "a1", "a2", "b1", "b2", "c1")
+	assert.NoError(t, err)
+
+	_, err = c.SADD("s2", "a1", "b1", "b2", "d1")
+	assert.NoError(t, err)
+
+	results, err := c.SMISMEMBER("s1", "a1", "d1", "a1", "c1")
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false, true, true}, results)
+
+	count, err := c.SINTERCARD(0, "s1", "s2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), count)
+
+	count, err = c.SINTERCARD(2, "s1", "s2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), count)
+
+	var members []string
+
+	cursor := ""
+	for {
+		var page []string
+
+		cursor, page, err = c.SSCAN("s1", cursor, "b*", 2)
+		assert.NoError(t, err)
+
+		members = append(members, page...)
+
+		if cursor == "" {
+			break
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"b1", "b2"}, members)
+
+	collect := func(members *[]string) func(string) bool {
+		return func(member string) bool {
+			*members = append(*members, member)
+			return true
+		}
+	}
+
+	var inter, union, diff []string
+
+	assert.NoError(t, c.SINTEREACH(collect(&inter), "s1", "s2"))
+	assert.ElementsMatch(t, []string{"a1", "b1", "b2"}, inter)
+
+	assert.NoError(t, c.SUNIONEACH(collect(&union), "s1", "s2"))
+	assert.ElementsMatch(t, []string{"a1", "a2", "b1", "b2", "c1", "d1"}, union)
+
+	assert.NoError(t, c.SDIFFEACH(collect(&diff), "s1", "s2"))
+	assert.ElementsMatch(t, []string{"a2", "c1"}, diff)
+
+	var first []string
+
+	assert.NoError(t, c.SUNIONEACH(func(member string) bool {
+		first = append(first, member)
+		return false
+	}, "s1", "s2"))
+	assert.Equal(t, 1, len(first))
+}
+
+func TestSetBatches(t *testing.T) {
+	c := newClient(t).BatchConcurrency(3)
+
+	var members []string
+	for i := 0; i < 120; i++ {
+		members = append(members, fmt.Sprintf("m%v", i))
+	}
+
+	err := c.SADDBATCH("s1", append(members, "m1", "m2")...)
+	assert.NoError(t, err)
+
+	count, err := c.SCARD("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(120), count)
+
+	err = c.SREMBATCH("s1", members[:100]...)
+	assert.NoError(t, err)
+
+	setMembers, err := c.SMEMBERS("s1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, members[100:], setMembers)
+
+	added, err := c.TransactionActions(7).SADDBATCHADDED("s1", members[90:110]...)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, members[90:100], added)
+
+	added, err = c.SADDBATCHADDED("s1", "m1", "m1", "new")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m1", "new"}, added)
+
+	count, err = c.SCARD("s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(32), count)
+}
+
+func TestSetStoresReplaceDestination(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.SADD("s1", "m1", "m2", "m3")
+	assert.NoError(t, err)
+
+	_, err = c.SADD("s2", "m2", "m3", "m4")
+	assert.NoError(t, err)
+
+	_, err = c.SADD("dest", "old1", "old2", "m2")
+	assert.NoError(t, err)
+
+	count, err := c.SINTERSTORE("dest", "s1", "s2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), count)
+
+	members, err := c.SMEMBERS("dest")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m2", "m3"}, members)
+
+	// too large for a single transaction, so the destination is left alone.
+	_, err = c.TransactionActions(3).SUNIONSTORE("dest", "s1", "s2")
+	assert.True(t, errors.Is(err, ErrStoreTooLarge))
+
+	members, err = c.SMEMBERS("dest")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m2", "m3"}, members)
+
+	count, err = c.SUNIONSTORE("dest", "s1", "s2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), count)
+
+	members, err = c.SMEMBERS("dest")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m1", "m2", "m3", "m4"}, members)
+
+	count, err = c.SDIFFSTORE("dest", "s1", "s1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), count)
+
+	members, err = c.SMEMBERS("dest")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
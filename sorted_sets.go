@@ -2,16 +2,36 @@ package redimo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// ZMember is a member of a sorted set along with its score, as returned by the ordered range APIs.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// zMembersMap converts ordered results into the member -> score map returned by the older APIs.
+func zMembersMap(members []ZMember) (membersWithScores map[string]float64) {
+	membersWithScores = make(map[string]float64, len(members))
+	for _, m := range members {
+		membersWithScores[m.Member] = m.Score
+	}
+
+	return
+}
+
 type ZAggregation string
 
 const (
@@ -82,18 +102,29 @@ func zScoreFromAV(av types.AttributeValue) float64 {
 	return ReturnValue{av}.Float()
 }
 
+// ZADD sets the scores of the given members, adding the members that don't exist yet. Besides IfNotExists and
+// IfAlreadyExists, the flags can contain IfGreaterThan (or IfLessThan), to only update existing members when the
+// new score is greater (or less) than the current one, and ReturnChanged, to also return the members whose
+// score was changed, instead of only the ones that were added. The conditions are checked by DynamoDB as part
+// of the write, so "keep the best score" is a single conditional write per member.
+//
+// Cost is O(1) / 1 WCU for each member.
+//
+// Works similar to https://redis.io/commands/zadd
 func (c Client) ZADD(key string, membersWithScores map[string]float64, flags Flags) (addedMembers []string, err error) {
+	if c.rankIndex != nil {
+		addedMembers, err = c.zRankedAdd(key, membersWithScores, flags)
+		if len(addedMembers) > 0 {
+			c.notifier.notify(c, key)
+		}
+
+		return addedMembers, err
+	}
+
 	for member, score := range membersWithScores {
 		builder := newExpresionBuilder()
 		builder.updateSetAV(c.sortKeyNum, zScore{score}.ToAV())
-
-		if flags.has(IfNotExists) {
-			builder.addConditionNotExists(c.partitionKey)
-		}
-
-		if flags.has(IfAlreadyExists) {
-			builder.addConditionExists(c.partitionKey)
-		}
+		c.zAddConditions(&builder, flags)
 
 		resp, err := c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 			ConditionExpression:       builder.conditionExpression(),
@@ -112,22 +143,189 @@ func (c Client) ZADD(key string, membersWithScores map[string]float64, flags Fla
 			return addedMembers, err
 		}
 
-		if len(resp.Attributes) == 0 {
+		switch {
+		case len(resp.Attributes) == 0:
+			addedMembers = append(addedMembers, member)
+		case flags.has(ReturnChanged) && zScoreFromAV(resp.Attributes[c.sortKeyNum]) != score:
 			addedMembers = append(addedMembers, member)
 		}
 	}
 
+	if len(addedMembers) > 0 {
+		c.notifier.notify(c, key)
+	}
+
 	return
 }
 
+// zAddConditions adds the conditions for the NX, XX, GT and LT flags. The new score must already be in the
+// builder's values under the score attribute.
+func (c Client) zAddConditions(builder *expressionBuilder, flags Flags) {
+	if flags.has(IfNotExists) {
+		builder.addConditionNotExists(c.partitionKey)
+	}
+
+	if flags.has(IfAlreadyExists) {
+		builder.addConditionExists(c.partitionKey)
+	}
+
+	if flags.has(IfGreaterThan) {
+		builder.condition(fmt.Sprintf("(attribute_not_exists(#%v) OR #%v < :%v)", c.partitionKey, c.sortKeyNum, c.sortKeyNum),
+			c.partitionKey, c.sortKeyNum)
+	}
+
+	if flags.has(IfLessThan) {
+		builder.condition(fmt.Sprintf("(attribute_not_exists(#%v) OR #%v > :%v)", c.partitionKey, c.sortKeyNum, c.sortKeyNum),
+			c.partitionKey, c.sortKeyNum)
+	}
+}
+
+// ZADDINCR increments the score of member by delta, like ZADD with the INCR option, and returns the new score.
+// The IfNotExists, IfAlreadyExists, IfGreaterThan and IfLessThan flags are respected; if the flags prevent the
+// update, ok will be false. With IfGreaterThan, for example, an existing member is only updated by a positive delta.
+//
+// Cost is O(1) / 1 WCU.
+//
+// Works similar to https://redis.io/commands/zadd with INCR
+func (c Client) ZADDINCR(key string, member string, delta float64, flags Flags) (newScore float64, ok bool, err error) {
+	if c.rankIndex != nil {
+		newScore, ok, err = c.zRankedIncr(key, member, delta, flags)
+		if ok {
+			c.notifier.notify(c, key)
+		}
+
+		return newScore, ok, err
+	}
+
+	builder := newExpresionBuilder()
+	builder.keys[c.sortKeyNum] = struct{}{}
+	builder.values["delta"] = zScore{delta}.ToAV()
+
+	if flags.has(IfNotExists) {
+		builder.addConditionNotExists(c.partitionKey)
+	}
+
+	if flags.has(IfAlreadyExists) {
+		builder.addConditionExists(c.partitionKey)
+	}
+
+	// the new score is the old one plus delta, so the GT / LT comparison only depends on the sign of delta.
+	if (flags.has(IfGreaterThan) && !(delta > 0)) || (flags.has(IfLessThan) && !(delta < 0)) {
+		builder.addConditionNotExists(c.partitionKey)
+	}
+
+	resp, err := c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: key, sk: member}.toAV(c),
+		ReturnValues:              types.ReturnValueAllNew,
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          aws.String(fmt.Sprintf("ADD #%v :delta", c.sortKeyNum)),
+	})
+
+	if conditionFailureError(err) {
+		return newScore, false, nil
+	}
+
+	if err != nil {
+		return newScore, false, err
+	}
+
+	c.notifier.notify(c, key)
+
+	return zScoreFromAV(resp.Attributes[c.sortKeyNum]), true, nil
+}
+
+// ZADDBATCH sets the scores of the given members like ZADD without flags, but writes them with batched
+// BatchWriteItem calls, several of them in parallel (see Client.BatchConcurrency). It can't report which
+// members were newly added, and doesn't support the NX / XX flags; use ZADD when those are needed.
+//
+// Cost is O(N) / 1 WCU for each member, with one request for every 25 members.
+func (c Client) ZADDBATCH(key string, membersWithScores map[string]float64) (err error) {
+	if c.rankIndex != nil {
+		// every member needs its own transaction to keep the rank index in sync.
+		_, err = c.ZADD(key, membersWithScores, Flags{})
+		return err
+	}
+
+	requests := make([]types.WriteRequest, 0, len(membersWithScores))
+
+	for member, score := range membersWithScores {
+		item := keyDef{pk: key, sk: member}.toAV(c)
+		item[c.sortKeyNum] = zScore{score}.ToAV()
+
+		requests = append(requests, c.putRequest(item))
+	}
+
+	err = c.batchWriteItems(requests)
+	if err == nil && len(requests) > 0 {
+		c.notifier.notify(c, key)
+	}
+
+	return err
+}
+
 func (c Client) ZCARD(key string) (count int32, err error) {
 	return c.HLEN(key)
 }
 
 func (c Client) ZCOUNT(key string, minScore, maxScore float64) (count int32, err error) {
+	if c.rankIndex != nil {
+		return c.zRankedCount(key, minScore, maxScore)
+	}
+
 	return c.zGeneralCount(key, zScore{minScore}, zScore{maxScore}, c.sortKeyNum)
 }
 
+// ZDIFF returns the members of the first sorted set that are not in any of the other sets, with their scores
+// from the first set.
+//
+// Cost is O(N) where N is the total size of the sets.
+//
+// Works similar to https://redis.io/commands/zdiff
+func (c Client) ZDIFF(sourceKeys ...string) (membersWithScores map[string]float64, err error) {
+	membersWithScores = make(map[string]float64)
+	if len(sourceKeys) == 0 {
+		return
+	}
+
+	membersWithScores, err = c.ZRANGEBYSCORE(sourceKeys[0], math.Inf(-1), math.Inf(+1), 0, 0)
+	if err != nil {
+		return
+	}
+
+	for _, sourceKey := range sourceKeys[1:] {
+		if len(membersWithScores) == 0 {
+			break
+		}
+
+		currentSet, err := c.ZRANGEBYSCORE(sourceKey, math.Inf(-1), math.Inf(+1), 0, 0)
+		if err != nil {
+			return membersWithScores, err
+		}
+
+		for member := range currentSet {
+			delete(membersWithScores, member)
+		}
+	}
+
+	return
+}
+
+// ZDIFFSTORE stores the result of ZDIFF in destinationKey, replacing its current members. The swap is atomic, so results too large
+// for a single transaction fail with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/zdiffstore
+func (c Client) ZDIFFSTORE(destinationKey string, sourceKeys ...string) (membersWithScores map[string]float64, err error) {
+	set, err := c.ZDIFF(sourceKeys...)
+	if err == nil {
+		err = c.zStore(destinationKey, set)
+	}
+
+	return set, err
+}
+
 func (c Client) zGeneralCount(key string, min rangeCap, max rangeCap, attribute string) (count int32, err error) {
 	builder := newExpresionBuilder()
 	builder.addConditionEquality(c.partitionKey, StringValue{key})
@@ -193,6 +391,11 @@ func (c Client) zGeneralCount(key string, min rangeCap, max rangeCap, attribute
 }
 
 func (c Client) ZINCRBY(key string, member string, delta float64) (newScore float64, err error) {
+	if c.rankIndex != nil {
+		newScore, _, err = c.zRankedIncr(key, member, delta, Flags{})
+		return newScore, err
+	}
+
 	builder := newExpresionBuilder()
 	builder.keys[c.sortKeyNum] = struct{}{}
 	builder.values["delta"] = zScore{delta}.ToAV()
@@ -218,104 +421,385 @@ func (c Client) ZINCRBY(key string, member string, delta float64) (newScore floa
 	return
 }
 
+// ZINTERSTORE stores the result of ZINTER in destinationKey, replacing its current members. The swap is atomic, so results too large
+// for a single transaction fail with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/zinterstore
 func (c Client) ZINTERSTORE(destinationKey string, sourceKeys []string, aggregation ZAggregation, weights map[string]float64) (membersWithScores map[string]float64, err error) {
 	set, err := c.ZINTER(sourceKeys, aggregation, weights)
 	if err == nil {
-		_, err = c.ZADD(destinationKey, set, Flags{})
+		err = c.zStore(destinationKey, set)
 	}
 
 	return set, err
 }
 
+// zStore replaces the members of the sorted set at key with the given members and scores.
+func (c Client) zStore(key string, membersWithScores map[string]float64) (err error) {
+	items := make(map[string]map[string]types.AttributeValue, len(membersWithScores))
+
+	for member, score := range membersWithScores {
+		item := keyDef{pk: key, sk: member}.toAV(c)
+		item[c.sortKeyNum] = zScore{score}.ToAV()
+		items[member] = item
+	}
+
+	err = c.replaceItems(key, items)
+	if err == nil && c.rankIndex != nil {
+		err = c.ZRANKINDEXREBUILD(key)
+	}
+
+	return err
+}
+
 func (c Client) ZLEXCOUNT(key string, min string, max string) (count int32, err error) {
 	return c.zGeneralCount(key, zLex{min}, zLex{max}, c.sortKey)
 }
 
+// ZMSCORE returns the scores of the given members in the same order as members, along with whether each
+// member was found in the set at key.
+//
+// Cost is O(N) / 1 RCU for each member, with one BatchGetItem call for every 100 members.
+//
+// Works similar to https://redis.io/commands/zmscore
+func (c Client) ZMSCORE(key string, members ...string) (scores []float64, found []bool, err error) {
+	unique := uniqueStrings(members)
+	keys := make([]map[string]types.AttributeValue, len(unique))
+
+	for i, member := range unique {
+		keys[i] = keyDef{pk: key, sk: member}.toAV(c)
+	}
+
+	items, err := c.batchGetItems(keys)
+	if err != nil {
+		return
+	}
+
+	membersWithScores := make(map[string]float64, len(items))
+	for _, item := range items {
+		membersWithScores[parseItem(item, c).sk] = zScoreFromAV(item[c.sortKeyNum])
+	}
+
+	scores = make([]float64, len(members))
+	found = make([]bool, len(members))
+
+	for i, member := range members {
+		scores[i], found[i] = membersWithScores[member]
+	}
+
+	return
+}
+
 func (c Client) ZPOPMAX(key string, count int32) (membersWithScores map[string]float64, err error) {
+	members, err := c.zPop(key, count, false)
+	return zMembersMap(members), err
+}
+
+// ZPOPMAXWITHSCORES works like ZPOPMAX, but returns the popped members in rank order, highest score first.
+func (c Client) ZPOPMAXWITHSCORES(key string, count int32) (members []ZMember, err error) {
 	return c.zPop(key, count, false)
 }
 
 func (c Client) ZPOPMIN(key string, count int32) (membersWithScores map[string]float64, err error) {
+	members, err := c.zPop(key, count, true)
+	return zMembersMap(members), err
+}
+
+// ZPOPMINWITHSCORES works like ZPOPMIN, but returns the popped members in rank order, lowest score first.
+func (c Client) ZPOPMINWITHSCORES(key string, count int32) (members []ZMember, err error) {
 	return c.zPop(key, count, true)
 }
 
+// zContentionRetries is how many times in a row zPop reads the range again without claiming any member before giving up.
+const zContentionRetries = 5
+
+// zTiesPageSize is how many members are read at a time while completing a group of tied scores.
+const zTiesPageSize = 100
+
 var negInf = zScore{math.Inf(-1)}
 var posInf = zScore{math.Inf(+1)}
 
-func (c Client) zPop(key string, count int32, forward bool) (membersWithScores map[string]float64, err error) {
-	membersWithScores, err = c.zGeneralRange(key, negInf, posInf, 0, count, forward, c.sortKeyNum)
-	if err != nil {
+// zPop claims up to count members from the low (forward) or high end of the sorted set at key. Each member is
+// claimed with a delete on the condition that it still has the score it was read with, so concurrent pops never
+// return the same member, and a member that was re-scored in the meantime isn't popped with its old score.
+// Members lost to other writers are replaced by reading the range again, up to zContentionRetries times in a
+// row without claiming anything.
+func (c Client) zPop(key string, count int32, forward bool) (poppedMembers []ZMember, err error) {
+	for retryCount := 0; int32(len(poppedMembers)) < count; {
+		members, err := c.zGeneralRangeOrdered(key, negInf, posInf, 0, count-int32(len(poppedMembers)), forward, c.sortKeyNum)
+		if err != nil || len(members) == 0 {
+			return poppedMembers, err
+		}
+
+		popped := len(poppedMembers)
+
+		for _, m := range members {
+			ok, err := c.zClaim(key, m)
+			if err != nil {
+				return poppedMembers, err
+			}
+
+			if ok {
+				poppedMembers = append(poppedMembers, m)
+			}
+		}
+
+		if len(poppedMembers) > popped {
+			retryCount = 0
+		} else if retryCount++; retryCount == zContentionRetries {
+			return poppedMembers, errors.New("too much contention")
+		}
+	}
+
+	return poppedMembers, nil
+}
+
+// zClaim deletes the member of the sorted set at key if it still has the given score, and reports whether it did.
+func (c Client) zClaim(key string, m ZMember) (ok bool, err error) {
+	if c.rankIndex != nil {
+		change, err := c.zRankedWrite(key, m.Member, func(old float64, existed bool) (float64, bool, bool) {
+			return old, true, existed && old == m.Score
+		})
+
+		return change.written, err
+	}
+
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(c.sortKeyNum, zScore{m.Score})
+
+	_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: key, sk: m.Member}.toAV(c),
+		TableName:                 aws.String(c.tableName),
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// BZPOPMIN is the blocking version of ZPOPMIN. It pops the member with the lowest score from the first non-empty
+// sorted set among the given keys, waiting until a member is available, the timeout expires or the context is
+// cancelled. A zero timeout waits indefinitely. Together with ZADD this turns a sorted set into a priority queue.
+//
+// If the timeout expires, the returned key is empty and there is no error. The sets are polled like in BLPOP,
+// and a PushNotifier attached to the client wakes the call as soon as ZADD adds a member in this process.
+//
+// Works similar to https://redis.io/commands/bzpopmin
+func (c Client) BZPOPMIN(ctx context.Context, timeout time.Duration, keys ...string) (key string, member ZMember, err error) {
+	return c.zBlockingPop(ctx, timeout, keys, true)
+}
+
+// BZPOPMAX is the blocking version of ZPOPMAX, and works the same way as BZPOPMIN except that the member with
+// the highest score is popped.
+//
+// Works similar to https://redis.io/commands/bzpopmax
+func (c Client) BZPOPMAX(ctx context.Context, timeout time.Duration, keys ...string) (key string, member ZMember, err error) {
+	return c.zBlockingPop(ctx, timeout, keys, false)
+}
+
+func (c Client) zBlockingPop(ctx context.Context, timeout time.Duration, keys []string, forward bool) (key string, member ZMember, err error) {
+	_, err = c.block(ctx, timeout, keys, func() (bool, error) {
+		for _, k := range keys {
+			popped, err := c.zPop(k, 1, forward)
+			if err != nil {
+				return false, err
+			}
+
+			if len(popped) > 0 {
+				key, member = k, popped[0]
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+
+	return
+}
+
+// ZRANDMEMBER returns random members of the sorted set at key. If count is positive, up to count distinct
+// members are returned; if it's negative, exactly -count members are returned, possibly with repetitions.
+//
+// The whole set is read to pick the members, so cost is O(N) where N is the size of the set.
+//
+// Works similar to https://redis.io/commands/zrandmember
+func (c Client) ZRANDMEMBER(key string, count int32) (members []string, err error) {
+	picked, err := c.ZRANDMEMBERWITHSCORES(key, count)
+
+	for _, m := range picked {
+		members = append(members, m.Member)
+	}
+
+	return
+}
+
+// ZRANDMEMBERWITHSCORES works like ZRANDMEMBER, but returns the scores of the members as well.
+//
+// Works similar to https://redis.io/commands/zrandmember with WITHSCORES
+func (c Client) ZRANDMEMBERWITHSCORES(key string, count int32) (members []ZMember, err error) {
+	if count == 0 {
 		return
 	}
 
-	poppedMembers := make(map[string]float64)
+	all, err := c.zGeneralRangeOrdered(key, negInf, posInf, 0, 0, true, c.sortKeyNum)
+	if err != nil || len(all) == 0 {
+		return
+	}
 
-	for member, score := range membersWithScores {
-		popped, err := c.ZREM(key, member)
-		if err != nil {
-			return poppedMembers, err
+	if count < 0 {
+		for i := int32(0); i < -count; i++ {
+			members = append(members, all[rand.Intn(len(all))])
 		}
 
-		if len(popped) > 0 {
-			poppedMembers[member] = score
+		return
+	}
+
+	for i, j := range rand.Perm(len(all)) {
+		if int32(i) >= count {
+			break
 		}
+
+		members = append(members, all[j])
 	}
 
-	return poppedMembers, err
+	return
 }
 
 func (c Client) ZRANGE(key string, start, stop int32) (membersWithScores map[string]float64, err error) {
+	members, err := c.zRange(key, start, stop, true)
+	return zMembersMap(members), err
+}
+
+// ZRANGEWITHSCORES works like ZRANGE, but returns the members in rank order. Members with the same score
+// are ordered lexicographically.
+//
+// Works similar to https://redis.io/commands/zrange with WITHSCORES
+func (c Client) ZRANGEWITHSCORES(key string, start, stop int32) (members []ZMember, err error) {
 	return c.zRange(key, start, stop, true)
 }
 
-func (c Client) zRange(key string, start int32, stop int32, forward bool) (membersWithScores map[string]float64, err error) {
+// zRange returns the members between the start and stop ranks (inclusive, negative ranks count from the end).
+func (c Client) zRange(key string, start int32, stop int32, forward bool) (members []ZMember, err error) {
 	if start < 0 && stop < 0 {
-		return c.zGeneralRange(key, negInf, posInf, -stop-1, -start, !forward, c.sortKeyNum)
+		if stop < start {
+			return
+		}
+
+		// read from the other end, and turn the result around.
+		members, err = c.zGeneralRangeOrdered(key, negInf, posInf, -stop-1, stop-start+1, !forward, c.sortKeyNum)
+		zReverse(members)
+
+		return members, err
 	}
 
-	if start > 0 && stop < 0 {
-		lastScore, err := c.zGeneralRange(key, negInf, posInf, -stop-1, 1, !forward, c.sortKeyNum)
+	if start < 0 || stop < 0 {
+		card, err := c.ZCARD(key)
 		if err != nil {
-			return membersWithScores, err
+			return members, err
+		}
+
+		if start < 0 {
+			start += card
+		}
+
+		if stop < 0 {
+			stop += card
 		}
 
-		return c.zGeneralRange(key, negInf, zScore{floatValues(lastScore)[0]}, start, 0, forward, c.sortKeyNum)
+		if start < 0 {
+			start = 0
+		}
 	}
 
-	return c.zGeneralRange(key, negInf, posInf, start, stop-start+1, forward, c.sortKeyNum)
+	if stop < start {
+		return
+	}
+
+	return c.zGeneralRangeOrdered(key, negInf, posInf, start, stop-start+1, forward, c.sortKeyNum)
 }
 
-func floatValues(floatValuedMap map[string]float64) (values []float64) {
-	for _, v := range floatValuedMap {
-		values = append(values, v)
+func zReverse(members []ZMember) {
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
 	}
-
-	return
 }
 
 func (c Client) ZRANGEBYLEX(key string, min, max string, offset, count int32) (membersWithScores map[string]float64, err error) {
 	return c.zGeneralRange(key, zLex{min}, zLex{max}, offset, count, true, c.sortKey)
 }
 
+// ZRANGEBYLEXWITHSCORES works like ZRANGEBYLEX, but returns the members in lexicographic order.
+func (c Client) ZRANGEBYLEXWITHSCORES(key string, min, max string, offset, count int32) (members []ZMember, err error) {
+	return c.zGeneralRangeOrdered(key, zLex{min}, zLex{max}, offset, count, true, c.sortKey)
+}
+
 func (c Client) ZRANGEBYSCORE(key string, min, max float64, offset, count int32) (membersWithScores map[string]float64, err error) {
 	return c.zGeneralRange(key, zScore{min}, zScore{max}, offset, count, true, c.sortKeyNum)
 }
 
+// ZRANGEBYSCOREWITHSCORES works like ZRANGEBYSCORE, but returns the members in rank order. Members with the
+// same score are ordered lexicographically, and offset and count are applied to that order.
+//
+// Works similar to https://redis.io/commands/zrangebyscore with WITHSCORES
+func (c Client) ZRANGEBYSCOREWITHSCORES(key string, min, max float64, offset, count int32) (members []ZMember, err error) {
+	return c.zGeneralRangeOrdered(key, zScore{min}, zScore{max}, offset, count, true, c.sortKeyNum)
+}
+
+// ZRANGESTORE stores the members between the start and stop ranks of the sorted set at sourceKey (see ZRANGE)
+// in destinationKey, replacing its current members. The swap is atomic, so results too large
+// for a single transaction fail with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/zrangestore
+func (c Client) ZRANGESTORE(destinationKey string, sourceKey string, start, stop int32) (membersWithScores map[string]float64, err error) {
+	members, err := c.zRange(sourceKey, start, stop, true)
+	if err != nil {
+		return
+	}
+
+	set := zMembersMap(members)
+
+	return set, c.zStore(destinationKey, set)
+}
+
 func (c Client) zGeneralRange(key string,
 	start rangeCap, stop rangeCap,
 	offset int32, count int32,
 	forward bool, attribute string) (membersWithScores map[string]float64, err error) {
-	membersWithScores = make(map[string]float64)
-	index := int32(0)
-	remainingCount := count
+	members, err := c.zGeneralRangeOrdered(key, start, stop, offset, count, forward, attribute)
+	return zMembersMap(members), err
+}
+
+// zGeneralRangeOrdered returns the members between start and stop on the given attribute in rank order,
+// skipping the first offset members and returning at most count of them (all of them if count is zero).
+//
+// Members with the same score are ordered lexicographically (in reverse, if not forward), like Redis. The
+// index doesn't order members with the same score, so when ranging by score the read continues past the last
+// member that's needed until the group of members tied with it is complete, and ties are sorted afterwards.
+func (c Client) zGeneralRangeOrdered(key string,
+	start rangeCap, stop rangeCap,
+	offset int32, count int32,
+	forward bool, attribute string) (members []ZMember, err error) {
+	needed := offset + count
+	byScore := attribute == c.sortKeyNum
 	hasMoreResults := true
 
 	var lastKey map[string]types.AttributeValue
 
 	for hasMoreResults {
 		var queryLimit *int32
-		if remainingCount > 0 {
-			queryLimit = aws.Int32(remainingCount + offset - index)
+		if count > 0 {
+			// one more than needed, to find out whether the last group of ties is complete.
+			limit := needed + 1 - int32(len(members))
+			if limit < 1 {
+				limit = zTiesPageSize
+			}
+
+			queryLimit = aws.Int32(limit)
 		}
 
 		builder := newExpresionBuilder()
@@ -339,7 +823,7 @@ func (c Client) zGeneralRange(key string,
 		}
 
 		var queryIndex *string
-		if attribute == c.sortKeyNum {
+		if byScore {
 			queryIndex = aws.String(c.indexName)
 		}
 
@@ -356,26 +840,52 @@ func (c Client) zGeneralRange(key string,
 		})
 
 		if err != nil {
-			return membersWithScores, err
+			return members, err
 		}
 
 		for _, item := range resp.Items {
-			if index >= offset {
-				pi := parseItem(item, c)
-				membersWithScores[pi.sk] = zScoreFromAV(item[c.sortKeyNum])
-				remainingCount--
-			}
-			index++
+			members = append(members, ZMember{
+				Member: parseItem(item, c).sk,
+				Score:  zScoreFromAV(item[c.sortKeyNum]),
+			})
 		}
 
-		if len(resp.LastEvaluatedKey) > 0 && remainingCount > 0 {
-			lastKey = resp.LastEvaluatedKey
-		} else {
+		switch {
+		case len(resp.LastEvaluatedKey) == 0:
 			hasMoreResults = false
+		case count > 0 && int32(len(members)) > needed:
+			hasMoreResults = byScore && members[len(members)-1].Score == members[needed-1].Score
 		}
+
+		lastKey = resp.LastEvaluatedKey
+	}
+
+	if byScore {
+		sort.SliceStable(members, func(i, j int) bool {
+			a, b := members[i], members[j]
+			if !forward {
+				a, b = b, a
+			}
+
+			if a.Score != b.Score {
+				return a.Score < b.Score
+			}
+
+			return a.Member < b.Member
+		})
 	}
 
-	return membersWithScores, nil
+	if offset >= int32(len(members)) {
+		return nil, nil
+	}
+
+	members = members[offset:]
+
+	if count > 0 && count < int32(len(members)) {
+		members = members[:count]
+	}
+
+	return members, nil
 }
 
 func (c Client) ZRANK(key string, member string) (rank int32, found bool, err error) {
@@ -383,6 +893,10 @@ func (c Client) ZRANK(key string, member string) (rank int32, found bool, err er
 }
 
 func (c Client) zRank(key string, member string, forward bool) (rank int32, ok bool, err error) {
+	if c.rankIndex != nil {
+		return c.zRankedRank(key, member, forward)
+	}
+
 	score, ok, err := c.ZSCORE(key, member)
 	if err != nil || !ok {
 		return
@@ -404,6 +918,10 @@ func (c Client) zRank(key string, member string, forward bool) (rank int32, ok b
 }
 
 func (c Client) ZREM(key string, members ...string) (removedMembers []string, err error) {
+	if c.rankIndex != nil {
+		return c.zRankedRem(key, members...)
+	}
+
 	for _, member := range members {
 		resp, err := c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 			Key:          keyDef{pk: key, sk: member}.toAV(c),
@@ -423,6 +941,24 @@ func (c Client) ZREM(key string, members ...string) (removedMembers []string, er
 	return
 }
 
+// ZREMBATCH removes the given members like ZREM, but with batched BatchWriteItem calls. It can't report
+// which members were actually removed; use ZREM when that's needed.
+//
+// Cost is O(N) / 1 WCU for each member, with one request for every 25 members.
+func (c Client) ZREMBATCH(key string, members ...string) (err error) {
+	if c.rankIndex != nil {
+		_, err = c.ZREM(key, members...)
+		return err
+	}
+
+	requests := make([]types.WriteRequest, 0, len(members))
+	for _, member := range uniqueStrings(members) {
+		requests = append(requests, c.deleteRequest(keyDef{pk: key, sk: member}.toAV(c)))
+	}
+
+	return c.batchWriteItems(requests)
+}
+
 func (c Client) ZREMRANGEBYLEX(key string, min, max string) (removedMembers []string, err error) {
 	membersWithScores, err := c.ZRANGEBYLEX(key, min, max, 0, 0)
 	if err == nil {
@@ -460,6 +996,15 @@ func (c Client) ZREMRANGEBYSCORE(key string, min, max float64) (removedMembers [
 }
 
 func (c Client) ZREVRANGE(key string, start, stop int32) (membersWithScores map[string]float64, err error) {
+	members, err := c.zRange(key, start, stop, false)
+	return zMembersMap(members), err
+}
+
+// ZREVRANGEWITHSCORES works like ZREVRANGE, but returns the members in rank order, highest score first.
+// Members with the same score are ordered in reverse lexicographic order.
+//
+// Works similar to https://redis.io/commands/zrevrange with WITHSCORES
+func (c Client) ZREVRANGEWITHSCORES(key string, start, stop int32) (members []ZMember, err error) {
 	return c.zRange(key, start, stop, false)
 }
 
@@ -467,10 +1012,24 @@ func (c Client) ZREVRANGEBYLEX(key string, max, min string, offset, count int32)
 	return c.zGeneralRange(key, zLex{min}, zLex{max}, offset, count, false, c.sortKey)
 }
 
+// ZREVRANGEBYLEXWITHSCORES works like ZREVRANGEBYLEX, but returns the members in reverse lexicographic order.
+func (c Client) ZREVRANGEBYLEXWITHSCORES(key string, max, min string, offset, count int32) (members []ZMember, err error) {
+	return c.zGeneralRangeOrdered(key, zLex{min}, zLex{max}, offset, count, false, c.sortKey)
+}
+
 func (c Client) ZREVRANGEBYSCORE(key string, max, min float64, offset, count int32) (membersWithScores map[string]float64, err error) {
 	return c.zGeneralRange(key, zScore{min}, zScore{max}, offset, count, false, c.sortKeyNum)
 }
 
+// ZREVRANGEBYSCOREWITHSCORES works like ZREVRANGEBYSCORE, but returns the members in rank order, highest score
+// first. Members with the same score are ordered in reverse lexicographic order, and offset and count are
+// applied to that order.
+//
+// Works similar to https://redis.io/commands/zrevrangebyscore with WITHSCORES
+func (c Client) ZREVRANGEBYSCOREWITHSCORES(key string, max, min float64, offset, count int32) (members []ZMember, err error) {
+	return c.zGeneralRangeOrdered(key, zScore{min}, zScore{max}, offset, count, false, c.sortKeyNum)
+}
+
 func (c Client) ZREVRANK(key string, member string) (rank int32, found bool, err error) {
 	return c.zRank(key, member, false)
 }
@@ -493,10 +1052,14 @@ func (c Client) ZSCORE(key string, member string) (score float64, found bool, er
 	return
 }
 
+// ZUNIONSTORE stores the result of ZUNION in destinationKey, replacing its current members. The swap is atomic, so results too large
+// for a single transaction fail with ErrStoreTooLarge.
+//
+// Works similar to https://redis.io/commands/zunionstore
 func (c Client) ZUNIONSTORE(destinationKey string, sourceKeys []string, aggregation ZAggregation, weights map[string]float64) (membersWithScores map[string]float64, err error) {
 	set, err := c.ZUNION(sourceKeys, aggregation, weights)
 	if err == nil {
-		_, err = c.ZADD(destinationKey, set, Flags{})
+		err = c.zStore(destinationKey, set)
 	}
 
 	return set, err
@@ -559,3 +1122,36 @@ func (c Client) ZINTER(sourceKeys []string, aggregation ZAggregation, weights ma
 
 	return
 }
+
+// ZINTERCARD returns the number of members in the intersection of the given sorted sets, counting up to limit
+// members if limit is positive. The smallest set is read a page at a time and its members are checked against
+// the other sets, stopping as soon as limit members are counted; the scores are never read or combined.
+//
+// Cost is O(K) for the sizes of the K sets, plus O(N * K) / 1 RCU for each member checked, where N is the size
+// of the smallest set, or less if the limit is reached early.
+//
+// Works similar to https://redis.io/commands/zintercard
+func (c Client) ZINTERCARD(limit int32, sourceKeys ...string) (count int32, err error) {
+	if len(sourceKeys) == 0 {
+		return
+	}
+
+	smallest, smallestSize := 0, int32(-1)
+
+	for i, key := range sourceKeys {
+		size, err := c.ZCARD(key)
+		if err != nil || size == 0 {
+			return 0, err
+		}
+
+		if smallestSize < 0 || size < smallestSize {
+			smallest, smallestSize = i, size
+		}
+	}
+
+	keys := append([]string(nil), sourceKeys...)
+	keys[0], keys[smallest] = keys[smallest], keys[0]
+
+	// sorted set members are stored under the same keys as set members, so they're checked the same way.
+	return c.SINTERCARD(limit, keys[0], keys[1:]...)
+}
@@ -1,8 +1,12 @@
 package redimo
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -386,3 +390,316 @@ func TestZAggregations(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, map[string]float64{"m3": 7}, set)
 }
+
+func TestZDiffsAndSampling(t *testing.T) {
+	c := newClient(t)
+	_, err := c.ZADD("z1", map[string]float64{"m1": 1, "m2": 2, "m3": 3, "m4": 4}, Flags{})
+	assert.NoError(t, err)
+	_, err = c.ZADD("z2", map[string]float64{"m2": 20, "m5": 5}, Flags{})
+	assert.NoError(t, err)
+	_, err = c.ZADD("z3", map[string]float64{"m4": 40}, Flags{})
+	assert.NoError(t, err)
+
+	set, err := c.ZDIFF("z1", "z2", "z3")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"m1": 1, "m3": 3}, set)
+
+	set, err = c.ZDIFFSTORE("diff1", "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(set))
+
+	set, err = c.ZRANGEBYSCORE("diff1", math.Inf(-1), math.Inf(+1), 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"m1": 1, "m3": 3, "m4": 4}, set)
+
+	count, err := c.ZINTERCARD(0, "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), count)
+
+	count, err = c.ZINTERCARD(1, "z1", "z1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), count)
+
+	count, err = c.ZINTERCARD(0, "z1", "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), count)
+
+	count, err = c.ZINTERCARD(0, "z1", "nosuchkey")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), count)
+
+	set, err = c.ZRANGESTORE("range1", "z1", 1, -2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"m2": 2, "m3": 3}, set)
+
+	members, err := c.ZRANGEWITHSCORES("range1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"m2", 2}, {"m3", 3}}, members)
+
+	scores, found, err := c.ZMSCORE("z1", "m2", "nope", "m4", "m2")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{2, 0, 4, 2}, scores)
+	assert.Equal(t, []bool{true, false, true, true}, found)
+
+	random, err := c.ZRANDMEMBER("z1", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(random))
+	assert.Equal(t, 3, len(uniqueStrings(random)))
+
+	random, err = c.ZRANDMEMBER("z1", 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m1", "m2", "m3", "m4"}, random)
+
+	random, err = c.ZRANDMEMBER("z3", -3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m4", "m4", "m4"}, random)
+
+	members, err = c.ZRANDMEMBERWITHSCORES("z2", 5)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []ZMember{{"m2", 20}, {"m5", 5}}, members)
+}
+
+func TestZBlockingPops(t *testing.T) {
+	c := newClient(t).Notifier(NewPushNotifier())
+
+	_, err := c.ZADD("z2", map[string]float64{"low": 1, "mid": 2, "high": 3}, Flags{})
+	assert.NoError(t, err)
+
+	key, member, err := c.BZPOPMIN(context.Background(), time.Second, "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, "z2", key)
+	assert.Equal(t, ZMember{"low", 1}, member)
+
+	key, member, err = c.BZPOPMAX(context.Background(), time.Second, "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, "z2", key)
+	assert.Equal(t, ZMember{"high", 3}, member)
+
+	members, err := c.ZPOPMINWITHSCORES("z2", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"mid", 2}}, members)
+
+	key, _, err = c.BZPOPMIN(context.Background(), 100*time.Millisecond, "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := c.ZADD("z1", map[string]float64{"wakeup": 7}, Flags{})
+		assert.NoError(t, err)
+	}()
+
+	key, member, err = c.BZPOPMAX(context.Background(), 10*time.Second, "z1", "z2")
+	assert.NoError(t, err)
+	assert.Equal(t, "z1", key)
+	assert.Equal(t, ZMember{"wakeup", 7}, member)
+
+	membersWithScores := make(map[string]float64)
+	for i := 0; i < 20; i++ {
+		membersWithScores[fmt.Sprintf("m%v", i)] = float64(i)
+	}
+
+	_, err = c.ZADD("z3", membersWithScores, Flags{})
+	assert.NoError(t, err)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		popped []string
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				members, err := c.ZPOPMIN("z3", 2)
+				assert.NoError(t, err)
+
+				if len(members) == 0 {
+					return
+				}
+
+				mu.Lock()
+				popped = append(popped, zReadKeys(members)...)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, 20, len(popped))
+	assert.Equal(t, 20, len(uniqueStrings(popped)))
+}
+
+func TestZBatches(t *testing.T) {
+	c := newClient(t)
+
+	membersWithScores := make(map[string]float64)
+	for i := 0; i < 60; i++ {
+		membersWithScores[fmt.Sprintf("m%v", i)] = float64(i)
+	}
+
+	err := c.ZADDBATCH("z1", membersWithScores)
+	assert.NoError(t, err)
+
+	count, err := c.ZCARD("z1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(60), count)
+
+	score, ok, err := c.ZSCORE("z1", "m42")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 42.0, score)
+
+	err = c.ZREMBATCH("z1", "m0", "m1", "m1")
+	assert.NoError(t, err)
+
+	count, err = c.ZCARD("z1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(58), count)
+}
+
+func TestZOrderedRanges(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.ZADD("z1", map[string]float64{
+		"d": 2, "b": 1, "a": 1, "c": 1, "e": 3,
+	}, Flags{})
+	assert.NoError(t, err)
+
+	members, err := c.ZRANGEWITHSCORES("z1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"a", 1}, {"b", 1}, {"c", 1}, {"d", 2}, {"e", 3}}, members)
+
+	members, err = c.ZRANGEWITHSCORES("z1", 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"b", 1}, {"c", 1}}, members)
+
+	members, err = c.ZRANGEWITHSCORES("z1", -2, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"d", 2}, {"e", 3}}, members)
+
+	members, err = c.ZREVRANGEWITHSCORES("z1", 0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"e", 3}, {"d", 2}, {"c", 1}, {"b", 1}}, members)
+
+	members, err = c.ZRANGEBYSCOREWITHSCORES("z1", 1, 2, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"b", 1}, {"c", 1}}, members)
+
+	members, err = c.ZREVRANGEBYSCOREWITHSCORES("z1", 1, 1, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"c", 1}, {"b", 1}}, members)
+
+	members, err = c.ZPOPMINWITHSCORES("z1", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"a", 1}, {"b", 1}}, members)
+
+	members, err = c.ZPOPMAXWITHSCORES("z1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"e", 3}}, members)
+}
+
+func TestZAddFlags(t *testing.T) {
+	c := newClient(t)
+
+	_, err := c.ZADD("z1", map[string]float64{"m1": 10, "m2": 20}, Flags{})
+	assert.NoError(t, err)
+
+	changed, err := c.ZADD("z1", map[string]float64{"m1": 5, "m2": 25, "m3": 1}, Flags{IfGreaterThan, ReturnChanged})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m2", "m3"}, changed)
+
+	added, err := c.ZADD("z1", map[string]float64{"m1": 5, "m2": 30, "m4": 1}, Flags{IfLessThan})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m4"}, added)
+
+	members, err := c.ZRANGEWITHSCORES("z1", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []ZMember{{"m3", 1}, {"m4", 1}, {"m1", 5}, {"m2", 25}}, members)
+
+	newScore, ok, err := c.ZADDINCR("z1", "m1", 3, Flags{IfGreaterThan})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 8.0, newScore)
+
+	_, ok, err = c.ZADDINCR("z1", "m1", -3, Flags{IfGreaterThan})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = c.ZADDINCR("z1", "m5", 1, Flags{IfAlreadyExists})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	newScore, ok, err = c.ZADDINCR("z1", "m5", 2, Flags{IfNotExists})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, newScore)
+}
+
+func TestZRankIndex(t *testing.T) {
+	c := newClient(t).RankIndex(0, 100, 8)
+
+	_, err := c.ZADD("z1", map[string]float64{
+		"a": 5, "b": 50, "c": 50, "d": 99, "e": 150, "f": -10,
+	}, Flags{})
+	assert.NoError(t, err)
+
+	rank, ok, err := c.ZRANK("z1", "f")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int32(0), rank)
+
+	rank, _, err = c.ZRANK("z1", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), rank)
+
+	rank, _, err = c.ZREVRANK("z1", "c")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), rank)
+
+	_, ok, err = c.ZRANK("z1", "nosuchmember")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	count, err := c.ZCOUNT("z1", 5, 99)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), count)
+
+	count, err = c.ZCOUNT("z1", math.Inf(-1), math.Inf(+1))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(6), count)
+
+	newScore, err := c.ZINCRBY("z1", "a", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 105.0, newScore)
+
+	rank, _, err = c.ZRANK("z1", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), rank)
+
+	_, err = c.ZREM("z1", "f", "e")
+	assert.NoError(t, err)
+
+	rank, _, err = c.ZRANK("z1", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), rank)
+
+	count, err = c.ZCOUNT("z1", 0, 60)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), count)
+
+	// written without the index, then rebuilt.
+	_, err = NewClient(c.ddbClient).Table(c.tableName).ZADD("z2", map[string]float64{"x": 1, "y": 2, "z": 3}, Flags{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.ZRANKINDEXREBUILD("z2"))
+
+	rank, _, err = c.ZREVRANK("z2", "x")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), rank)
+}
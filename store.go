@@ -0,0 +1,124 @@
+package redimo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const storeContentionRetries = 5
+
+// ErrStoreTooLarge is returned by the *STORE commands when replacing the destination would take more writes
+// than fit in a single transaction (see Client.TransactionActions).
+var ErrStoreTooLarge = errors.New("the store result doesn't fit in a single transaction")
+
+// storeVersionKey returns the version counter of the destination, which every *STORE operation on it bumps.
+func storeVersionKey(key string) keyDef {
+	return keyDef{pk: fmt.Sprintf("_redimo/store/%v", key), sk: "version"}
+}
+
+// replaceItems overwrites the contents of key with the given items, keyed by their sort key, like the
+// *STORE commands in Redis: members that are not part of the new contents are removed.
+//
+// The removal of the old members and the writes of the new ones happen in a single transaction, so readers
+// see either the old or the new contents. The transaction also bumps the version of the destination that was
+// read before listing its members, so that two concurrent stores to the same destination can't mix their
+// results; the one that loses starts over. A result that needs more writes than fit in a transaction (counting
+// the version bump) is rejected with ErrStoreTooLarge, and the destination is left unchanged.
+func (c Client) replaceItems(key string, items map[string]map[string]types.AttributeValue) (err error) {
+	for retryCount := 0; retryCount < storeContentionRetries; retryCount++ {
+		resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+			ConsistentRead: aws.Bool(true),
+			Key:            storeVersionKey(key).toAV(c),
+			TableName:      aws.String(c.tableName),
+		})
+		if err != nil {
+			return err
+		}
+
+		actions, err := c.replaceActions(key, items)
+		if err != nil || len(actions) == 0 {
+			return err
+		}
+
+		if len(actions)+1 > c.transactionActions {
+			return fmt.Errorf("%w: %d writes needed, at most %d allowed", ErrStoreTooLarge, len(actions)+1, c.transactionActions)
+		}
+
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]types.TransactWriteItem{c.storeVersionAction(key, resp.Item)}, actions...),
+		})
+
+		if conditionFailureError(err) {
+			// another store replaced the destination in the meantime.
+			continue
+		}
+
+		return err
+	}
+
+	return errors.New("too much contention")
+}
+
+// storeVersionAction bumps the version of the destination, on the condition that it's still the one in current.
+func (c Client) storeVersionAction(key string, current map[string]types.AttributeValue) types.TransactWriteItem {
+	builder := newExpresionBuilder()
+
+	if version := current[vk]; version != nil {
+		builder.addConditionEquality(vk, ReturnValue{version})
+	} else {
+		builder.addConditionNotExists(vk)
+	}
+
+	builder.clauses["ADD"] = append(builder.clauses["ADD"], fmt.Sprintf("#%v :delta", vk))
+	builder.keys[vk] = struct{}{}
+	builder.values["delta"] = IntValue{1}.ToAV()
+
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			ConditionExpression:       builder.conditionExpression(),
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			Key:                       storeVersionKey(key).toAV(c),
+			TableName:                 aws.String(c.tableName),
+			UpdateExpression:          builder.updateExpression(),
+		},
+	}
+}
+
+// replaceActions returns the deletes of the current members of key that are not among items, followed by the
+// puts of the items.
+func (c Client) replaceActions(key string, items map[string]map[string]types.AttributeValue) (actions []types.TransactWriteItem, err error) {
+	current, err := c.listSortKeys(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range current {
+		if _, ok := items[member]; ok {
+			continue
+		}
+
+		actions = append(actions, types.TransactWriteItem{
+			Delete: &types.Delete{
+				Key:       keyDef{pk: key, sk: member}.toAV(c),
+				TableName: aws.String(c.tableName),
+			},
+		})
+	}
+
+	for _, item := range items {
+		actions = append(actions, types.TransactWriteItem{
+			Put: &types.Put{
+				Item:      item,
+				TableName: aws.String(c.tableName),
+			},
+		})
+	}
+
+	return actions, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,16 +14,23 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-// XID holds a stream item ID, and consists of a timestamp (one second resolution)
-// and a sequence number.
+// XID holds a stream item ID, and consists of a timestamp (millisecond resolution, like Redis)
+// and a sequence number. XIDs are stored with both parts zero-padded to 20 digits, so that they sort
+// correctly as DynamoDB sort keys; use ParseXID to read IDs in the Redis form (1526919030474-55) and
+// RedisString to print them in that form.
 //
 // Most code will not need to generate XIDs – using XAutoID with XADD is the most common usage.
 // But if you do need to generate XIDs for insertion with XADD, the NewXID methods creates a complete XID.
 //
+// Older versions of this library used timestamps with one second resolution. Those legacy XIDs sort before
+// every millisecond XID, so existing streams keep working. An XID can't tell by itself which kind it is, so
+// the stream records which of its XIDs are legacy ones the first time it's written to by this version (see
+// XLEGACYEND), and XMIGRATEIDS rewrites those items with millisecond XIDs.
+//
 // To generate time based XIDs for time range queries with XRANGE or XREVRANGE, use
 // NewTimeXID(startTime).First() and NewTimeXID(endTime).Last(). Calling Last() is especially important
-// because without it none of the items in the last second of the range will match – you need
-// the last possible sequence number in the last second of the range, which is what the Last() method provides.
+// because without it none of the items in the last millisecond of the range will match – you need
+// the last possible sequence number in the last millisecond of the range, which is what the Last() method provides.
 type XID string
 
 var ErrXGroupNotInitialized = errors.New("consumer group not initialized with XGROUP")
@@ -31,16 +39,86 @@ const consumerKey = "cnk"
 const lastDeliveryTimestampKey = "ldk"
 const deliveryCountKey = "dck"
 
+// The sequence item of a stream records the last XID added in vk, along with the number of items in the stream
+// and a lower bound of the XID of its first item, so the length and ends of the stream can be read in O(1).
+// Streams written by older versions of this library also record the last XID they wrote, up to which the
+// XIDs of the stream are legacy (one second resolution) ones, until they are migrated.
+const xLengthKey = "len"
+const xFirstKey = "first"
+const xLegacyKey = "legacy"
+
 const XStart XID = "00000000000000000000-00000000000000000000"
 const XEnd XID = "99999999999999999999-99999999999999999999"
 const XAutoID XID = "*"
 
+const xContentionRetries = 5
+
 // NewXID creates an XID with the given timestamp and sequence number.
 func NewXID(ts time.Time, seq uint64) XID {
-	timePart := fmt.Sprintf("%020d", ts.Unix())
-	sequencePart := fmt.Sprintf("%020d", seq)
+	return newXIDFromParts(uint64(timeToMillis(ts)), seq)
+}
+
+func newXIDFromParts(ms uint64, seq uint64) XID {
+	return XID(fmt.Sprintf("%020d-%020d", ms, seq))
+}
+
+// ParseXID parses and validates a stream ID, either in the Redis form (1526919030474-55, or just
+// 1526919030474 for sequence number zero) or in the zero-padded form used for storage. The special
+// IDs "-", "+" and "*" are returned as XStart, XEnd and XAutoID.
+func ParseXID(id string) (xid XID, err error) {
+	switch id {
+	case "-":
+		return XStart, nil
+	case "+":
+		return XEnd, nil
+	case "*":
+		return XAutoID, nil
+	}
+
+	parts := strings.Split(id, "-")
+	if len(parts) == 1 {
+		parts = append(parts, "0")
+	}
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || len(parts[0]) > 20 || len(parts[1]) > 20 {
+		return xid, fmt.Errorf("invalid stream ID %q", id)
+	}
+
+	if id == XStart.String() || id == XEnd.String() {
+		return XID(id), nil
+	}
+
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return xid, fmt.Errorf("invalid stream ID %q: %w", id, err)
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return xid, fmt.Errorf("invalid stream ID %q: %w", id, err)
+	}
+
+	return newXIDFromParts(ms, seq), nil
+}
+
+// RedisString returns the XID in the Redis form, like 1526919030474-55.
+func (xid XID) RedisString() string {
+	return fmt.Sprintf("%d-%d", xid.timestamp(), xid.Seq())
+}
 
-	return XID(strings.Join([]string{timePart, sequencePart}, "-"))
+// Migrated returns the millisecond XID for the same time and sequence number as a legacy XID, created by an
+// older version of this library with a timestamp in seconds. It's only meaningful for the XIDs of a stream
+// up to its XLEGACYEND.
+func (xid XID) Migrated() XID {
+	return newXIDFromParts(xid.timestamp()*1000, xid.Seq())
+}
+
+// timestamp returns the time part of the XID as it's stored, in milliseconds (or seconds for legacy XIDs).
+func (xid XID) timestamp() uint64 {
+	parts := strings.Split(xid.String(), "-")
+	ts, _ := strconv.ParseUint(parts[0], 10, 64)
+
+	return ts
 }
 
 // NewTimeXID creates an XID with the given timestamp. To get the first or the last
@@ -64,7 +142,11 @@ func xSequenceKey(key string) keyDef {
 func (xid XID) sequenceUpdateAction(key string, c Client) types.TransactWriteItem {
 	builder := newExpresionBuilder()
 	builder.condition(fmt.Sprintf("#%v < :%v", vk, vk), vk)
+	builder.addConditionExists(xLengthKey)
 	builder.SET(fmt.Sprintf("#%v = :%v", vk, vk), vk, StringValue{xid.String()}.ToAV())
+	builder.SET(fmt.Sprintf("#%v = if_not_exists(#%v, :%v)", xFirstKey, xFirstKey, vk), xFirstKey, StringValue{xid.String()}.ToAV())
+	builder.clauses["ADD"] = append(builder.clauses["ADD"], fmt.Sprintf("#%v :delta", xLengthKey))
+	builder.values["delta"] = IntValue{1}.ToAV()
 
 	return types.TransactWriteItem{
 		Update: &types.Update{
@@ -80,7 +162,7 @@ func (xid XID) sequenceUpdateAction(key string, c Client) types.TransactWriteIte
 
 // Next returns the next valid XID at the same time – it simply returns a new XID with the next sequence number.
 func (xid XID) Next() XID {
-	return NewXID(xid.Time(), xid.Seq()+1)
+	return newXIDFromParts(xid.timestamp(), xid.Seq()+1)
 }
 
 // Prev returns the previous valid XID at the same time – it simply returns a new XID with the previous sequence number.
@@ -89,15 +171,12 @@ func (xid XID) Prev() XID {
 		return xid
 	}
 
-	return NewXID(xid.Time(), xid.Seq()-1)
+	return newXIDFromParts(xid.timestamp(), xid.Seq()-1)
 }
 
-// Time returns the time represented by this XID, accurate to one second.
+// Time returns the time represented by this XID, accurate to one millisecond. For legacy XIDs, use Migrated().Time().
 func (xid XID) Time() time.Time {
-	parts := strings.Split(xid.String(), "-")
-	tsec, _ := strconv.ParseInt(parts[0], 10, 64)
-
-	return time.Unix(tsec, 0)
+	return millisToTime(int64(xid.timestamp()))
 }
 
 // Seq returns the sequence number represented by this XID. To get the next and previous
@@ -115,17 +194,15 @@ func (xid XID) av() types.AttributeValue {
 
 // First returns the first valid XID at this timestamp. Useful for the start parameter of XRANGE or XREVRANGE.
 func (xid XID) First() XID {
-	timePart := fmt.Sprintf("%020d", xid.Time().Unix())
-	return XID(strings.Join([]string{timePart, "00000000000000000000"}, "-"))
+	return newXIDFromParts(xid.timestamp(), 0)
 }
 
 // Last returns the last valid XID at this timestamp. Useful for the end parameter of XRANGE or XREVRANGE.
 // Note that if the XID used as an end in the range simply based on the timestamp, the sequence number will be zero,
-// so the query will exclude all the items in end second. This will effectively transform the query to '< endTime'
+// so the query will exclude all the items in end millisecond. This will effectively transform the query to '< endTime'
 // instead of '<= endTime'. Using Last() prevents this mistake, if that is your intention.
 func (xid XID) Last() XID {
-	timePart := fmt.Sprintf("%020d", xid.Time().Unix())
-	return XID(strings.Join([]string{timePart, "99999999999999999999"}, "-"))
+	return XID(fmt.Sprintf("%020d-99999999999999999999", xid.timestamp()))
 }
 
 type StreamItem struct {
@@ -233,7 +310,8 @@ func (c Client) XACK(key string, group string, ids ...XID) (acknowledgedIds []XI
 // it will be initialized.
 //
 // If the XID passed in is XAutoID, an ID will be automatically generated on the current time
-// and a sequence generator.
+// and a sequence generator. Other IDs may be given in the Redis form (1526919030474-55) as well,
+// see ParseXID; invalid IDs are rejected.
 //
 // Note that if you pass in your own ID, the stream will never allow you to insert an item with
 // an ID less than the greatest ID present in the stream – the stream can only move forwards. This
@@ -242,6 +320,11 @@ func (c Client) XACK(key string, group string, ids ...XID) (acknowledgedIds []XI
 //
 // Works similar to https://redis.io/commands/xadd
 func (c Client) XADD(key string, id XID, fields map[string]Value) (returnedID XID, err error) {
+	id, err = ParseXID(id.String())
+	if err != nil {
+		return returnedID, err
+	}
+
 	retry := true
 	retryCount := 0
 
@@ -291,12 +374,29 @@ func (c Client) XADD(key string, id XID, fields map[string]Value) (returnedID XI
 	return id, err
 }
 
+// XADDTRIM adds an item to the stream at key like XADD, and then trims the stream as described by trim, like
+// XADD with the MAXLEN or MINID options. Use an approximate trim (see XTrim.Approximate) to keep the cost of
+// each call bounded.
+//
+// Works similar to https://redis.io/commands/xadd
+func (c Client) XADDTRIM(key string, id XID, fields map[string]Value, trim XTrim) (returnedID XID, err error) {
+	returnedID, err = c.XADD(key, id, fields)
+	if err != nil {
+		return
+	}
+
+	_, err = c.XTRIMWITH(key, trim)
+
+	return
+}
+
 func (c Client) xInit(key string) (err error) {
 	_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
 		TransactItems: []types.TransactWriteItem{c.xInitAction(key)},
 	})
 	if conditionFailureError(err) {
-		err = nil
+		// the stream already exists, but may have been created before its length was recorded.
+		return c.xInitLength(key)
 	}
 
 	return
@@ -306,6 +406,7 @@ func (c Client) xInitAction(key string) types.TransactWriteItem {
 	builder := newExpresionBuilder()
 	builder.addConditionNotExists(vk)
 	builder.SET(fmt.Sprintf("#%v = :%v", vk, vk), vk, StringValue{XStart.String()}.ToAV())
+	builder.updateSET(xLengthKey, IntValue{0})
 
 	return types.TransactWriteItem{
 		Update: &types.Update{
@@ -319,32 +420,100 @@ func (c Client) xInitAction(key string) types.TransactWriteItem {
 	}
 }
 
-func (c Client) XCLAIM(key string, group string, consumer string, lastDeliveredBefore time.Time, ids ...XID) (items []StreamItem, err error) {
-	for _, id := range ids {
-		builder := newExpresionBuilder()
-		builder.addConditionExists(c.partitionKey)
-		builder.addConditionLessThanOrEqualTo(lastDeliveryTimestampKey, IntValue{lastDeliveredBefore.Unix()})
-		builder.updateSET(lastDeliveryTimestampKey, IntValue{time.Now().Unix()})
-		builder.updateSET(deliveryCountKey, IntValue{0})
-		builder.updateSET(consumerKey, StringValue{consumer})
+// xInitLength records the length and first XID of a stream created before they were kept in its sequence
+// item, by counting its items once. Nothing is done if the stream doesn't exist or its length is already recorded.
+// Such a stream was written by an older version of this library, so its last XID is recorded as the end of
+// its legacy XIDs as well.
+//
+// Writes to the stream require the length to be recorded, so none can happen while the items are counted.
+func (c Client) xInitLength(key string) (err error) {
+	sequence, err := c.xSequence(key)
+	if err != nil || len(sequence) == 0 || sequence[xLengthKey] != nil {
+		return
+	}
 
-		_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	count, err := c.xCount(key, XStart, XEnd)
+	if err != nil {
+		return
+	}
+
+	first, err := c.xRange(key, XStart, XEnd, 1, true)
+	if err != nil {
+		return
+	}
+
+	builder := newExpresionBuilder()
+	builder.addConditionExists(vk)
+	builder.addConditionNotExists(xLengthKey)
+	builder.updateSET(xLengthKey, IntValue{int64(count)})
+
+	if last := XID(ReturnValue{sequence[vk]}.String()); last != XStart {
+		builder.updateSET(xLegacyKey, StringValue{last.String()})
+	}
+
+	if len(first) > 0 {
+		builder.updateSET(xFirstKey, StringValue{first[0].ID.String()})
+	}
+
+	_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       xSequenceKey(key).toAV(c),
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	if conditionFailureError(err) {
+		// recorded in the meantime.
+		return nil
+	}
+
+	return err
+}
+
+func (c Client) xSequence(key string) (item map[string]types.AttributeValue, err error) {
+	resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            xSequenceKey(key).toAV(c),
+		TableName:      aws.String(c.tableName),
+	})
+	if err != nil {
+		return
+	}
+
+	return resp.Item, nil
+}
+
+func (c Client) xLengthUpdateAction(key string, delta int64) types.TransactWriteItem {
+	builder := newExpresionBuilder()
+	builder.addConditionExists(xLengthKey)
+	builder.clauses["ADD"] = append(builder.clauses["ADD"], fmt.Sprintf("#%v :delta", xLengthKey))
+	builder.values["delta"] = IntValue{delta}.ToAV()
+
+	return types.TransactWriteItem{
+		Update: &types.Update{
 			ConditionExpression:       builder.conditionExpression(),
 			ExpressionAttributeNames:  builder.expressionAttributeNames(),
 			ExpressionAttributeValues: builder.expressionAttributeValues(),
-			Key:                       keyDef{pk: c.xGroupKey(key, group), sk: id.String()}.toAV(c),
+			Key:                       xSequenceKey(key).toAV(c),
 			TableName:                 aws.String(c.tableName),
 			UpdateExpression:          builder.updateExpression(),
-		})
-
-		if conditionFailureError(err) {
-			continue
-		}
+		},
+	}
+}
 
+func (c Client) XCLAIM(key string, group string, consumer string, lastDeliveredBefore time.Time, ids ...XID) (items []StreamItem, err error) {
+	for _, id := range ids {
+		ok, err := c.xClaimPending(key, group, consumer, lastDeliveredBefore, id, false)
 		if err != nil {
 			return items, err
 		}
 
+		if !ok {
+			continue
+		}
+
 		fetchedItems, err := c.XRANGE(key, id, id, 1)
 
 		if err != nil || len(fetchedItems) < 1 {
@@ -357,30 +526,237 @@ func (c Client) XCLAIM(key string, group string, consumer string, lastDeliveredB
 	return items, nil
 }
 
+// xClaimPending assigns the pending item id of the group to consumer, if it was last delivered no later than
+// lastDeliveredBefore. The delivery count is either reset (like XCLAIM) or incremented (like XAUTOCLAIM).
+func (c Client) xClaimPending(key string, group string, consumer string, lastDeliveredBefore time.Time, id XID, countDelivery bool) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionExists(c.partitionKey)
+	builder.addConditionLessThanOrEqualTo(lastDeliveryTimestampKey, IntValue{lastDeliveredBefore.Unix()})
+	builder.updateSET(lastDeliveryTimestampKey, IntValue{time.Now().Unix()})
+	builder.updateSET(consumerKey, StringValue{consumer})
+
+	if countDelivery {
+		builder.clauses["ADD"] = append(builder.clauses["ADD"], fmt.Sprintf("#%v :delta", deliveryCountKey))
+		builder.keys[deliveryCountKey] = struct{}{}
+		builder.values["delta"] = IntValue{1}.ToAV()
+	} else {
+		builder.updateSET(deliveryCountKey, IntValue{0})
+	}
+
+	_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       keyDef{pk: c.xGroupKey(key, group), sk: id.String()}.toAV(c),
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// xAutoClaimAttemptsFactor bounds the pending items XAUTOCLAIM examines per call, as a multiple of the count.
+const xAutoClaimAttemptsFactor = 10
+
+// XAUTOCLAIM claims up to count pending items of the group that were last delivered at least minIdle ago
+// for consumer, examining the pending items in XID order starting at start, and returns the claimed items
+// along with the XID to pass as start to continue the scan. When the scan reaches the end of the pending
+// items, next is XStart, so calling XAUTOCLAIM in a loop keeps cycling through them:
+//
+//	next := XStart
+//	for {
+//		next, items, deleted, err = XAUTOCLAIM(key, group, consumer, time.Minute, next, 10)
+//		// process items...
+//	}
+//
+// Pending items whose stream items were deleted in the meantime are removed from the group, and their XIDs
+// returned as deleted. The delivery count of each claimed item is incremented. At most 10 × count pending
+// items are examined per call, to keep its cost bounded when few of them are idle.
+//
+// Cost is O(N) for the examined pending items, plus 1 WCU and the size of the item for each claimed item.
+//
+// Works similar to https://redis.io/commands/xautoclaim
+func (c Client) XAUTOCLAIM(key string, group string, consumer string, minIdle time.Duration, start XID, count int32) (next XID, items []StreamItem, deleted []XID, err error) {
+	if _, err = c.xGroupCursorGet(key, group); err != nil {
+		return
+	}
+
+	lastDeliveredBefore := time.Now().Add(-minIdle)
+	attempts := count * xAutoClaimAttemptsFactor
+	next = start
+
+	for attempts > 0 {
+		pendingItems, lastEvaluated, scanned, err := c.xPendingPage(key, group, XPendingOptions{Start: next, MinIdle: minIdle}, attempts)
+		if err != nil {
+			return next, items, deleted, err
+		}
+
+		for _, pendingItem := range pendingItems {
+			if int32(len(items)) == count {
+				return pendingItem.ID, items, deleted, nil
+			}
+
+			ok, err := c.xClaimPending(key, group, consumer, lastDeliveredBefore, pendingItem.ID, true)
+			if err != nil {
+				return pendingItem.ID, items, deleted, err
+			}
+
+			if !ok {
+				// claimed or acknowledged by someone else in the meantime.
+				continue
+			}
+
+			fetchedItems, err := c.XRANGE(key, pendingItem.ID, pendingItem.ID, 1)
+			if err != nil {
+				return pendingItem.ID, items, deleted, err
+			}
+
+			if len(fetchedItems) == 0 {
+				if _, err = c.XACK(key, group, pendingItem.ID); err != nil {
+					return pendingItem.ID, items, deleted, err
+				}
+
+				deleted = append(deleted, pendingItem.ID)
+
+				continue
+			}
+
+			items = append(items, fetchedItems[0])
+		}
+
+		if lastEvaluated == "" {
+			return XStart, items, deleted, nil
+		}
+
+		next = lastEvaluated.Next()
+		attempts -= scanned
+
+		if int32(len(items)) == count {
+			break
+		}
+	}
+
+	return next, items, deleted, nil
+}
+
 // XDEL removes the given IDs and returns the IDs that were actually deleted as part of this operation.
 //
 // Note that this operation is not atomic across given IDs – it's possible that an error is returned
 // based on a problem deleting one of the IDs when the others have been deleted. Even when an error is returned,
 // the items that were deleted will still be populated.
 //
+// Cost is O(N) / 2 WCUs for each deleted item, to keep the length of the stream up to date.
+//
 // Works similar to https://redis.io/commands/xdel
 func (c Client) XDEL(key string, ids ...XID) (deletedItems []XID, err error) {
+	return c.xDelete(key, ids)
+}
+
+// xDelete deletes the given items from the stream at key, updating its length, in transactions of up to
+// transactionActions - 1 items. If some of the items in a transaction don't exist anymore, they are deleted
+// one at a time instead, to find out which ones did.
+func (c Client) xDelete(key string, ids []XID) (deletedItems []XID, err error) {
+	chunkSize := c.transactionActions - 1
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	// a transaction can't refer to the same item twice.
+	seen := make(map[XID]struct{}, len(ids))
+	unique := make([]XID, 0, len(ids))
+
 	for _, id := range ids {
-		resp, err := c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-			Key:          keyDef{pk: key, sk: id.String()}.toAV(c),
-			ReturnValues: types.ReturnValueAllOld,
-			TableName:    aws.String(c.tableName),
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			unique = append(unique, id)
+		}
+	}
+
+	ids = unique
+
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		ids = ids[len(chunk):]
+
+		if len(chunk) > 1 {
+			_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+				TransactItems: c.xDeleteActions(key, chunk),
+			})
+
+			if err == nil {
+				deletedItems = append(deletedItems, chunk...)
+				continue
+			}
+
+			if !conditionFailureError(err) {
+				return deletedItems, err
+			}
+		}
+
+		for _, id := range chunk {
+			ok, err := c.xDeleteOne(key, id)
+			if err != nil {
+				return deletedItems, err
+			}
+
+			if ok {
+				deletedItems = append(deletedItems, id)
+			}
+		}
+	}
+
+	return deletedItems, nil
+}
+
+func (c Client) xDeleteOne(key string, id XID) (ok bool, err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: c.xDeleteActions(key, []XID{id}),
 		})
-		if err != nil {
-			return deletedItems, err
+
+		if err == nil {
+			return true, nil
 		}
 
-		if len(resp.Attributes) > 0 {
-			deletedItems = append(deletedItems, id)
+		if !conditionFailureError(err) {
+			return false, err
+		}
+
+		// either the item doesn't exist, or the stream's length isn't recorded yet.
+		if err = c.xInitLength(key); err != nil {
+			return false, err
 		}
 	}
 
-	return
+	return false, nil
+}
+
+func (c Client) xDeleteActions(key string, ids []XID) []types.TransactWriteItem {
+	actions := make([]types.TransactWriteItem, 0, len(ids)+1)
+
+	for _, id := range ids {
+		builder := newExpresionBuilder()
+		builder.addConditionExists(c.partitionKey)
+
+		actions = append(actions, types.TransactWriteItem{
+			Delete: &types.Delete{
+				ConditionExpression:      builder.conditionExpression(),
+				ExpressionAttributeNames: builder.expressionAttributeNames(),
+				Key:                      keyDef{pk: key, sk: id.String()}.toAV(c),
+				TableName:                aws.String(c.tableName),
+			},
+		})
+	}
+
+	return append(actions, c.xLengthUpdateAction(key, -int64(len(ids))))
 }
 
 // XGROUP creates a new group for the stream at the given key. Specifying the start XID
@@ -388,66 +764,265 @@ func (c Client) XDEL(key string, ids ...XID) (deletedItems []XID, err error) {
 // and any existing or generated XID can be used to denote a custom starting point.
 //
 // This is a required initialization step before the group can be used. Trying to use
-// XREADGROUP without using XGROUP to initialize the group will return an error.
+// XREADGROUP without using XGROUP to initialize the group will return an error. The group
+// is also registered with the stream, so that XINFOGROUPS lists it; groups created by older
+// versions of this library are only listed once XGROUP is called for them again.
 //
-// Cost is O(1) / 1 WCU.
+// Cost is O(1) / 2 WCUs.
 //
 // Works similar to https://redis.io/commands/xgroup
 func (c Client) XGROUP(key string, group string, start XID) (err error) {
 	err = c.xGroupCursorSet(key, group, start)
-	return
-}
+	if err != nil {
+		return
+	}
 
-func (c Client) xGroupCursorSet(key string, group string, start XID) error {
-	cursorKey := c.xGroupCursorKey(key, group)
-	_, err := c.HSET(cursorKey.pk, map[string]Value{cursorKey.sk: StringValue{start.String()}})
+	_, err = c.HSET(c.xGroupsKey(key), map[string]Value{group: IntValue{time.Now().Unix()}})
 
-	return err
+	return
 }
 
-func (c Client) xGroupCursorGet(key string, group string) (id XID, err error) {
-	resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
-		ConsistentRead: aws.Bool(true),
-		Key:            c.xGroupCursorKey(key, group).toAV(c),
-		TableName:      aws.String(c.tableName),
-	})
-	if err != nil {
-		return
-	}
+// XGROUPDESTROY deletes the group, along with its cursor, its registered consumers and all its pending items.
+// If the group doesn't exist, ok will be false.
+//
+// Cost is O(P) / 1 WCU for each pending item of the group.
+//
+// Works similar to https://redis.io/commands/xgroup-destroy
+func (c Client) XGROUPDESTROY(key string, group string) (ok bool, err error) {
+	if _, err = c.xGroupCursorGet(key, group); err != nil {
+		if errors.Is(err, ErrXGroupNotInitialized) {
+			err = nil
+		}
 
-	cursor := ReturnValue{resp.Item[vk]}.String()
-	if cursor == "" {
-		return id, ErrXGroupNotInitialized
+		return false, err
 	}
 
-	return XID(cursor), nil
-}
+	if _, err = c.delPartition(c.xGroupKey(key, group)); err != nil {
+		return false, err
+	}
 
-func (c Client) xGroupCursorKey(key string, group string) keyDef {
-	return keyDef{pk: c.xGroupKey(key, group), sk: "_redimo/cursor"}
-}
+	_, err = c.HDEL(c.xGroupsKey(key), group)
 
-func (c Client) xGroupKey(key string, group string) string {
-	return strings.Join([]string{"_redimo", key, group}, "/")
+	return err == nil, err
 }
 
-// XLEN counts the number of items in the stream with XIDs between the given XIDs. To count
-// the entire stream, pass XStart and XEnd as the start and end XIDs.
+// XGROUPSETID moves the cursor of an existing group to the given XID, so its consumers continue reading with the
+// items after it. Passing XStart makes the group read the stream from the beginning again. Pending items are not
+// affected. ErrXGroupNotInitialized is returned if the group doesn't exist.
 //
-// Cost is O(N) or ~N RCUs where N is the number / size of items counted.
+// Cost is O(1) / 1 WCU.
 //
-// Works similar to https://redis.io/commands/xlen
-func (c Client) XLEN(key string, start, stop XID) (count int32, err error) {
-	hasMoreResults := true
-
-	var cursor map[string]types.AttributeValue
+// Works similar to https://redis.io/commands/xgroup-setid
+func (c Client) XGROUPSETID(key string, group string, id XID) (err error) {
+	id, err = ParseXID(id.String())
+	if err != nil {
+		return
+	}
+
+	builder := newExpresionBuilder()
+	builder.addConditionExists(vk)
+	builder.updateSET(vk, StringValue{id.String()})
+
+	_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       c.xGroupCursorKey(key, group).toAV(c),
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	if conditionFailureError(err) {
+		return ErrXGroupNotInitialized
+	}
+
+	return err
+}
+
+// XGROUPCREATECONSUMER registers a consumer with the group, so that XINFOCONSUMERS lists it even when it has no
+// pending items. Consumers don't need to be registered to read from the group. If the consumer was already
+// registered, created will be false. ErrXGroupNotInitialized is returned if the group doesn't exist.
+//
+// Cost is O(1) / 1 WCU.
+//
+// Works similar to https://redis.io/commands/xgroup-createconsumer
+func (c Client) XGROUPCREATECONSUMER(key string, group string, consumer string) (created bool, err error) {
+	if _, err = c.xGroupCursorGet(key, group); err != nil {
+		return
+	}
+
+	return c.HSETNX(c.xGroupKey(key, group), xConsumerPrefix+consumer, IntValue{time.Now().Unix()})
+}
+
+// XGROUPDELCONSUMER removes a consumer from the group, and returns the number of items that were pending for it.
+// If reassignTo is empty, the pending items are dropped, as if they were acknowledged with XACK; otherwise they
+// are reassigned to the consumer named by reassignTo, keeping their delivery times and counts. Pending items that
+// are claimed by another consumer while this runs are left alone.
+//
+// Cost is O(P) where P is the number of pending items of the group, plus 1 WCU for each pending item of the consumer.
+//
+// Works similar to https://redis.io/commands/xgroup-delconsumer
+func (c Client) XGROUPDELCONSUMER(key string, group string, consumer string, reassignTo string) (pending int32, err error) {
+	if _, err = c.xGroupCursorGet(key, group); err != nil {
+		return
+	}
+
+	options := XPendingOptions{Consumer: consumer}
+
+	for {
+		pendingItems, lastEvaluated, _, err := c.xPendingPage(key, group, options, xConsumerPageSize)
+		if err != nil {
+			return pending, err
+		}
+
+		for _, pendingItem := range pendingItems {
+			ok, err := c.xReleasePending(key, group, pendingItem.ID, consumer, reassignTo)
+			if err != nil {
+				return pending, err
+			}
+
+			if ok {
+				pending++
+			}
+		}
+
+		if lastEvaluated == "" {
+			break
+		}
+
+		options.Start = lastEvaluated.Next()
+	}
+
+	_, err = c.HDEL(c.xGroupKey(key, group), xConsumerPrefix+consumer)
+
+	return pending, err
+}
+
+// xReleasePending deletes the pending item of the group, or reassigns it to another consumer if reassignTo isn't
+// empty, as long as it's still pending for consumer.
+func (c Client) xReleasePending(key string, group string, id XID, consumer string, reassignTo string) (ok bool, err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(consumerKey, StringValue{consumer})
+
+	itemKey := keyDef{pk: c.xGroupKey(key, group), sk: id.String()}.toAV(c)
+
+	if reassignTo == "" {
+		_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			ConditionExpression:       builder.conditionExpression(),
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			Key:                       itemKey,
+			TableName:                 aws.String(c.tableName),
+		})
+	} else {
+		builder.updateSET(consumerKey, StringValue{reassignTo})
+
+		_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			ConditionExpression:       builder.conditionExpression(),
+			ExpressionAttributeNames:  builder.expressionAttributeNames(),
+			ExpressionAttributeValues: builder.expressionAttributeValues(),
+			Key:                       itemKey,
+			TableName:                 aws.String(c.tableName),
+			UpdateExpression:          builder.updateExpression(),
+		})
+	}
+
+	if conditionFailureError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (c Client) xGroupCursorSet(key string, group string, start XID) error {
+	cursorKey := c.xGroupCursorKey(key, group)
+	_, err := c.HSET(cursorKey.pk, map[string]Value{cursorKey.sk: StringValue{start.String()}})
+
+	return err
+}
+
+func (c Client) xGroupCursorGet(key string, group string) (id XID, err error) {
+	resp, err := c.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            c.xGroupCursorKey(key, group).toAV(c),
+		TableName:      aws.String(c.tableName),
+	})
+	if err != nil {
+		return
+	}
+
+	cursor := ReturnValue{resp.Item[vk]}.String()
+	if cursor == "" {
+		return id, ErrXGroupNotInitialized
+	}
+
+	return XID(cursor), nil
+}
+
+// xConsumerPrefix prefixes the fields consumers are registered with in the group's partition, next to its cursor.
+const xConsumerPrefix = "_redimo/consumers/"
+
+// xConsumerPageSize is the number of pending items XGROUPDELCONSUMER examines per query.
+const xConsumerPageSize = 100
+
+func (c Client) xGroupCursorKey(key string, group string) keyDef {
+	return keyDef{pk: c.xGroupKey(key, group), sk: "_redimo/cursor"}
+}
+
+func (c Client) xGroupKey(key string, group string) string {
+	return strings.Join([]string{"_redimo", key, group}, "/")
+}
+
+// xGroupsKey is the hash the groups of a stream are registered in, with the group names as fields.
+func (c Client) xGroupsKey(key string) string {
+	return strings.Join([]string{"_redimo", "xgroups", key}, "/")
+}
+
+// xDestroy deletes what the stream at key keeps outside of its partition, for DEL: the partitions of its consumer
+// groups, the registry of the groups and the sequence item. Streams always have a sequence item, so nothing
+// else is read or written if key isn't a stream.
+func (c Client) xDestroy(key string) (err error) {
+	sequence, err := c.xSequence(key)
+	if err != nil || len(sequence) == 0 {
+		return
+	}
+
+	groups, err := c.HKEYS(c.xGroupsKey(key), "")
+	if err != nil {
+		return
+	}
+
+	for _, group := range groups {
+		if _, err = c.delPartition(c.xGroupKey(key, group)); err != nil {
+			return
+		}
+	}
+
+	if _, err = c.delPartition(c.xGroupsKey(key)); err != nil {
+		return
+	}
+
+	_, err = c.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		Key:       xSequenceKey(key).toAV(c),
+		TableName: aws.String(c.tableName),
+	})
+
+	return
+}
+
+// xGroupScanPending calls fn with every pending item of the group, in XID order.
+func (c Client) xGroupScanPending(key string, group string, fn func(PendingItem)) (err error) {
+	hasMoreResults := true
+
+	var cursor map[string]types.AttributeValue
 
 	for hasMoreResults {
 		builder := newExpresionBuilder()
-		builder.addConditionEquality(c.partitionKey, StringValue{key})
+		builder.addConditionEquality(c.partitionKey, StringValue{c.xGroupKey(key, group)})
 		builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKey), c.sortKey)
-		builder.values["start"] = start.av()
-		builder.values["stop"] = stop.av()
+		builder.values["start"] = XStart.av()
+		builder.values["stop"] = XEnd.av()
+
 		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
 			ConsistentRead:            aws.Bool(c.consistentReads),
 			ExclusiveStartKey:         cursor,
@@ -455,12 +1030,11 @@ func (c Client) XLEN(key string, start, stop XID) (count int32, err error) {
 			ExpressionAttributeValues: builder.expressionAttributeValues(),
 			KeyConditionExpression:    builder.conditionExpression(),
 			ScanIndexForward:          aws.Bool(true),
-			Select:                    types.SelectCount,
 			TableName:                 aws.String(c.tableName),
 		})
 
 		if err != nil {
-			return count, err
+			return err
 		}
 
 		if len(resp.LastEvaluatedKey) > 0 {
@@ -469,37 +1043,254 @@ func (c Client) XLEN(key string, start, stop XID) (count int32, err error) {
 			hasMoreResults = false
 		}
 
-		count += resp.Count
+		for _, item := range resp.Items {
+			fn(parsePendingItem(item, c))
+		}
 	}
 
+	return nil
+}
+
+// XStreamInfo describes a stream, as returned by XINFOSTREAM.
+type XStreamInfo struct {
+	Length          int32
+	Groups          int32
+	LastGeneratedID XID
+	FirstEntry      StreamItem
+	LastEntry       StreamItem
+}
+
+// XGroupInfo describes a consumer group, as returned by XINFOGROUPS. Consumers counts the consumers
+// that have pending items or were registered with XGROUPCREATECONSUMER.
+type XGroupInfo struct {
+	Name            string
+	Consumers       int32
+	Pending         int32
+	LastDeliveredID XID
+}
+
+// XConsumerInfo describes a consumer of a group, as returned by XINFOCONSUMERS. Idle is the time since
+// an item was last delivered to the consumer (or since it was registered, if that's later), with one
+// second resolution.
+type XConsumerInfo struct {
+	Name    string
+	Pending int32
+	Idle    time.Duration
+}
+
+// XINFOSTREAM returns the length, the first and last items, the last generated XID and the number of
+// registered groups of the stream at key. The items are left empty if the stream is empty, and a stream
+// that doesn't exist returns the zero XStreamInfo.
+//
+// Cost is O(1) / 3 RCUs (plus the size of the first and last items), plus O(G) for the groups.
+//
+// Works similar to https://redis.io/commands/xinfo-stream
+func (c Client) XINFOSTREAM(key string) (info XStreamInfo, err error) {
+	sequence, err := c.xSequence(key)
+	if err != nil || len(sequence) == 0 {
+		return
+	}
+
+	if sequence[xLengthKey] == nil {
+		if err = c.xInitLength(key); err != nil {
+			return
+		}
+
+		if sequence, err = c.xSequence(key); err != nil {
+			return
+		}
+	}
+
+	info.Length = int32(ReturnValue{sequence[xLengthKey]}.Int())
+
+	info.LastGeneratedID = XID(ReturnValue{sequence[vk]}.String())
+	if info.LastGeneratedID == XStart {
+		info.LastGeneratedID = ""
+	}
+
+	first := XID(ReturnValue{sequence[xFirstKey]}.String())
+	if first == "" {
+		first = XStart
+	}
+
+	items, err := c.xRange(key, first, XEnd, 1, true)
+	if err != nil {
+		return
+	}
+
+	if len(items) > 0 {
+		info.FirstEntry = items[0]
+	}
+
+	items, err = c.xRange(key, first, XEnd, 1, false)
+	if err != nil {
+		return
+	}
+
+	if len(items) > 0 {
+		info.LastEntry = items[0]
+	}
+
+	info.Groups, err = c.HLEN(c.xGroupsKey(key))
+
 	return
 }
 
-func (c Client) XPENDING(key string, group string, count int32) (pendingItems []PendingItem, err error) {
+// XINFOGROUPS returns the groups registered with the stream at key by XGROUP, ordered by name, with the
+// number of consumers and pending items of each, and the XID of the last item delivered to the group.
+//
+// Cost is O(G + P) where G is the number of groups and P the number of pending items across them.
+//
+// Works similar to https://redis.io/commands/xinfo-groups
+func (c Client) XINFOGROUPS(key string) (groups []XGroupInfo, err error) {
+	names, err := c.HKEYS(c.xGroupsKey(key), "")
+	if err != nil {
+		return
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		cursor, err := c.xGroupCursorGet(key, name)
+		if err != nil {
+			return groups, err
+		}
+
+		consumers, err := c.XINFOCONSUMERS(key, name)
+		if err != nil {
+			return groups, err
+		}
+
+		info := XGroupInfo{Name: name, Consumers: int32(len(consumers)), LastDeliveredID: cursor}
+		for _, consumer := range consumers {
+			info.Pending += consumer.Pending
+		}
+
+		groups = append(groups, info)
+	}
+
+	return groups, nil
+}
+
+// XINFOCONSUMERS returns the consumers of the group that have pending items or were registered with
+// XGROUPCREATECONSUMER, ordered by name, with the number of items pending for each and how long it's
+// been since an item was last delivered to them. ErrXGroupNotInitialized is returned if the group
+// wasn't created with XGROUP.
+//
+// Cost is O(P + C) where P is the number of pending items of the group and C the number of registered consumers.
+//
+// Works similar to https://redis.io/commands/xinfo-consumers
+func (c Client) XINFOCONSUMERS(key string, group string) (consumers []XConsumerInfo, err error) {
+	if _, err = c.xGroupCursorGet(key, group); err != nil {
+		return
+	}
+
+	pending := make(map[string]int32)
+	lastDelivered := make(map[string]time.Time)
+
+	err = c.xGroupScanPending(key, group, func(item PendingItem) {
+		pending[item.Consumer]++
+
+		if item.LastDelivered.After(lastDelivered[item.Consumer]) {
+			lastDelivered[item.Consumer] = item.LastDelivered
+		}
+	})
+	if err != nil {
+		return
+	}
+
+	fields, err := c.HKEYS(c.xGroupKey(key, group), xConsumerPrefix)
+	if err != nil {
+		return
+	}
+
+	if len(fields) > 0 {
+		registered, err := c.HMGET(c.xGroupKey(key, group), fields...)
+		if err != nil {
+			return consumers, err
+		}
+
+		for field, created := range registered {
+			if created.Empty() {
+				// deleted in the meantime.
+				continue
+			}
+
+			name := strings.TrimPrefix(field, xConsumerPrefix)
+			if _, ok := pending[name]; !ok {
+				pending[name] = 0
+			}
+
+			if at := time.Unix(created.Int(), 0); at.After(lastDelivered[name]) {
+				lastDelivered[name] = at
+			}
+		}
+	}
+
+	now := time.Now()
+
+	for name, count := range pending {
+		idle := now.Sub(lastDelivered[name])
+		if idle < 0 {
+			idle = 0
+		}
+
+		consumers = append(consumers, XConsumerInfo{Name: name, Pending: count, Idle: idle})
+	}
+
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+
+	return consumers, nil
+}
+
+// XLEN counts the number of items in the stream with XIDs between the given XIDs. To count
+// the entire stream, pass XStart and XEnd as the start and end XIDs; the length of the entire
+// stream is kept up to date by XADD, XDEL and XTRIM, and reset by DEL, so it's read directly.
+//
+// Cost is O(1) / 1 RCU for the entire stream, otherwise O(N) or ~N RCUs where N is the number / size of items counted.
+//
+// Works similar to https://redis.io/commands/xlen
+func (c Client) XLEN(key string, start, stop XID) (count int32, err error) {
+	if start == XStart && stop == XEnd {
+		sequence, err := c.xSequence(key)
+		if err != nil {
+			return count, err
+		}
+
+		if length := sequence[xLengthKey]; length != nil {
+			return int32(ReturnValue{length}.Int()), nil
+		}
+	}
+
+	return c.xCount(key, start, stop)
+}
+
+func (c Client) xCount(key string, start, stop XID) (count int32, err error) {
 	hasMoreResults := true
 
 	var cursor map[string]types.AttributeValue
 
-	for hasMoreResults && count > 0 {
+	for hasMoreResults {
 		builder := newExpresionBuilder()
-		builder.addConditionEquality(c.partitionKey, StringValue{c.xGroupKey(key, group)})
+		builder.addConditionEquality(c.partitionKey, StringValue{key})
 		builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKey), c.sortKey)
-		builder.values["start"] = XStart.av()
-		builder.values["stop"] = XEnd.av()
-
+		builder.values["start"] = start.av()
+		builder.values["stop"] = stop.av()
 		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
 			ConsistentRead:            aws.Bool(c.consistentReads),
 			ExclusiveStartKey:         cursor,
 			ExpressionAttributeNames:  builder.expressionAttributeNames(),
 			ExpressionAttributeValues: builder.expressionAttributeValues(),
 			KeyConditionExpression:    builder.conditionExpression(),
-			Limit:                     aws.Int32(count),
 			ScanIndexForward:          aws.Bool(true),
+			Select:                    types.SelectCount,
 			TableName:                 aws.String(c.tableName),
 		})
 
 		if err != nil {
-			return pendingItems, err
+			return count, err
 		}
 
 		if len(resp.LastEvaluatedKey) > 0 {
@@ -508,15 +1299,273 @@ func (c Client) XPENDING(key string, group string, count int32) (pendingItems []
 			hasMoreResults = false
 		}
 
-		for _, item := range resp.Items {
-			pendingItems = append(pendingItems, parsePendingItem(item, c))
-			count--
-		}
+		count += resp.Count
 	}
 
 	return
 }
 
+// XLEGACYEND returns the last legacy (one second resolution) XID of the stream at key, if it was written by
+// an older version of this library and hasn't been migrated with XMIGRATEIDS yet. Every XID of the stream up to
+// and including end is a legacy one, and every XID after it is a millisecond XID. If the stream has no legacy
+// XIDs, ok will be false.
+//
+// Cost is O(1) / 1 RCU, plus counting the items of the stream once if it hasn't been written to by this version.
+func (c Client) XLEGACYEND(key string) (end XID, ok bool, err error) {
+	if err = c.xInitLength(key); err != nil {
+		return
+	}
+
+	sequence, err := c.xSequence(key)
+	if err != nil || sequence[xLegacyKey] == nil {
+		return end, false, err
+	}
+
+	return XID(ReturnValue{sequence[xLegacyKey]}.String()), true, nil
+}
+
+// XMIGRATEIDS rewrites the items of the stream at key that have legacy (one second resolution) XIDs with the
+// equivalent millisecond XIDs (see XID.Migrated and XLEGACYEND), and returns how many items were rewritten. The
+// relative order of the items doesn't change, and new items can be added to the stream while it runs.
+//
+// Consumer groups refer to items by their XIDs, so pending items of legacy XIDs should be acknowledged before
+// migrating, and the cursors of existing groups moved with XGROUP afterwards.
+//
+// An item is never overwritten: if the millisecond XID of a legacy item is already taken, the migration stops
+// with an error, leaving that item and the ones after it unchanged.
+//
+// Cost is O(N) / 2 WCUs for each item that's rewritten, where N is the number of items with legacy XIDs.
+func (c Client) XMIGRATEIDS(key string) (migrated int, err error) {
+	legacyEnd, ok, err := c.XLEGACYEND(key)
+	if err != nil || !ok {
+		return
+	}
+
+	chunkSize := c.transactionActions / 2
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	for retryCount := 0; ; {
+		if retryCount >= xContentionRetries {
+			return migrated, errors.New("too much contention")
+		}
+
+		var items []StreamItem
+
+		items, err = c.xRange(key, XStart, legacyEnd, int32(chunkSize), true)
+		if err != nil {
+			return migrated, err
+		}
+
+		if len(items) == 0 {
+			break
+		}
+
+		actions := make([]types.TransactWriteItem, 0, 2*len(items))
+
+		for _, item := range items {
+			put := StreamItem{ID: item.ID.Migrated(), Fields: item.Fields}.putAction(key, c)
+			put.Put.ConditionExpression = aws.String(fmt.Sprintf("attribute_not_exists(#%v)", c.sortKey))
+			put.Put.ExpressionAttributeNames = map[string]string{"#" + c.sortKey: c.sortKey}
+
+			builder := newExpresionBuilder()
+			builder.addConditionExists(c.partitionKey)
+
+			actions = append(actions, put, types.TransactWriteItem{
+				Delete: &types.Delete{
+					ConditionExpression:       builder.conditionExpression(),
+					ExpressionAttributeNames:  builder.expressionAttributeNames(),
+					ExpressionAttributeValues: builder.expressionAttributeValues(),
+					Key:                       keyDef{pk: key, sk: item.ID.String()}.toAV(c),
+					TableName:                 aws.String(c.tableName),
+				},
+			})
+		}
+
+		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+			TransactItems: actions,
+		})
+
+		for _, failed := range transactionConditionFailures(err) {
+			if failed%2 == 0 {
+				// the puts are at even positions: never overwrite an item that already has the migrated XID.
+				id := items[failed/2].ID
+				return migrated, fmt.Errorf("can't migrate stream ID %v: %v already exists", id, id.Migrated().RedisString())
+			}
+		}
+
+		if conditionFailureError(err) {
+			// items were deleted while they were being migrated, read them again.
+			retryCount++
+			continue
+		}
+
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated += len(items)
+		retryCount = 0
+	}
+
+	return migrated, c.xMigrateSequence(key, legacyEnd)
+}
+
+// xMigrateSequence moves the last XID recorded for the stream at key to its millisecond equivalent, if it's
+// still the last legacy XID, and then drops the legacy marker.
+func (c Client) xMigrateSequence(key string, legacyEnd XID) (err error) {
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(vk, StringValue{legacyEnd.String()})
+	builder.SET(fmt.Sprintf("#%v = :%v", vk, vk), vk, StringValue{legacyEnd.Migrated().String()}.ToAV())
+
+	_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       xSequenceKey(key).toAV(c),
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	// a condition failure means a newer XID was added in the meantime.
+	if err != nil && !conditionFailureError(err) {
+		return err
+	}
+
+	builder = newExpresionBuilder()
+	builder.addConditionEquality(xLegacyKey, StringValue{legacyEnd.String()})
+	builder.clauses["REMOVE"] = append(builder.clauses["REMOVE"], "#"+xLegacyKey)
+
+	_, err = c.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		ConditionExpression:       builder.conditionExpression(),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		Key:                       xSequenceKey(key).toAV(c),
+		TableName:                 aws.String(c.tableName),
+		UpdateExpression:          builder.updateExpression(),
+	})
+
+	if conditionFailureError(err) {
+		// migrated concurrently.
+		return nil
+	}
+
+	return err
+}
+
+// XPENDING returns up to count pending items of the group, in XID order. It's a shorthand for
+// XPENDINGWITH(key, group, XPendingOptions{}, count).
+//
+// Works similar to https://redis.io/commands/xpending
+func (c Client) XPENDING(key string, group string, count int32) (pendingItems []PendingItem, err error) {
+	return c.XPENDINGWITH(key, group, XPendingOptions{}, count)
+}
+
+// XPendingOptions narrows down the pending items returned by XPENDINGWITH. Start and End bound the XIDs
+// (inclusive, defaulting to XStart and XEnd), Consumer only matches items pending for that consumer, and
+// MinIdle only matches items that were last delivered at least that long ago (with one second resolution).
+type XPendingOptions struct {
+	Start    XID
+	End      XID
+	Consumer string
+	MinIdle  time.Duration
+}
+
+// XPENDINGWITH returns up to count pending items of the group that match the options, in XID order – the
+// extended form of XPENDING. To page through the pending items, pass the XID of the last item returned,
+// with Next(), as the start of the next call.
+//
+// Cost is O(N) where N is the number of pending items examined, which includes the ones filtered out by
+// the consumer and idle time.
+//
+// Works similar to https://redis.io/commands/xpending
+func (c Client) XPENDINGWITH(key string, group string, options XPendingOptions, count int32) (pendingItems []PendingItem, err error) {
+	for count > 0 {
+		page, lastEvaluated, _, err := c.xPendingPage(key, group, options, count)
+		if err != nil {
+			return pendingItems, err
+		}
+
+		if int32(len(page)) > count {
+			page = page[:count]
+		}
+
+		pendingItems = append(pendingItems, page...)
+		count -= int32(len(page))
+
+		if lastEvaluated == "" {
+			break
+		}
+
+		options.Start = lastEvaluated.Next()
+	}
+
+	return pendingItems, nil
+}
+
+// xPendingPage runs a single query for the pending items of the group matching the options, examining up to
+// limit items. It returns the matching items, the XID of the last item examined if there may be more (or an
+// empty XID otherwise), and the number of items examined.
+func (c Client) xPendingPage(key string, group string, options XPendingOptions, limit int32) (pendingItems []PendingItem, lastEvaluated XID, scanned int32, err error) {
+	if options.Start == "" {
+		options.Start = XStart
+	}
+
+	if options.End == "" {
+		options.End = XEnd
+	}
+
+	builder := newExpresionBuilder()
+	builder.addConditionEquality(c.partitionKey, StringValue{c.xGroupKey(key, group)})
+	builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKey), c.sortKey)
+	builder.values["start"] = options.Start.av()
+	builder.values["stop"] = options.End.av()
+
+	var filters []string
+
+	if options.Consumer != "" {
+		filters = append(filters, fmt.Sprintf("#%v = :%v", consumerKey, consumerKey))
+		builder.keys[consumerKey] = struct{}{}
+		builder.values[consumerKey] = StringValue{options.Consumer}.ToAV()
+	}
+
+	if options.MinIdle > 0 {
+		filters = append(filters, fmt.Sprintf("#%v <= :%v", lastDeliveryTimestampKey, lastDeliveryTimestampKey))
+		builder.keys[lastDeliveryTimestampKey] = struct{}{}
+		builder.values[lastDeliveryTimestampKey] = IntValue{time.Now().Add(-options.MinIdle).Unix()}.ToAV()
+	}
+
+	var filterExpression *string
+	if len(filters) > 0 {
+		filterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
+		ConsistentRead:            aws.Bool(c.consistentReads),
+		ExpressionAttributeNames:  builder.expressionAttributeNames(),
+		ExpressionAttributeValues: builder.expressionAttributeValues(),
+		FilterExpression:          filterExpression,
+		KeyConditionExpression:    builder.conditionExpression(),
+		Limit:                     aws.Int32(limit),
+		ScanIndexForward:          aws.Bool(true),
+		TableName:                 aws.String(c.tableName),
+	})
+	if err != nil {
+		return
+	}
+
+	for _, item := range resp.Items {
+		pendingItems = append(pendingItems, parsePendingItem(item, c))
+	}
+
+	if len(resp.LastEvaluatedKey) > 0 {
+		lastEvaluated = XID(parseKey(resp.LastEvaluatedKey, c).sk)
+	}
+
+	return pendingItems, lastEvaluated, resp.ScannedCount, nil
+}
+
 // XRANGE fetches the stream records between two XIDs, inclusive of both the start and end IDs, limited to the count.
 //
 // If you receive the entire count you've asked for, it's reasonable to suppose there might be more items in the given
@@ -603,10 +1652,12 @@ func parseStreamItem(item map[string]types.AttributeValue, c Client) (si StreamI
 	return
 }
 
-func (c Client) xGroupCursorPushAction(key string, group string, id XID) types.TransactWriteItem {
+// xGroupCursorAdvanceAction moves the cursor of the group from the XID it was read at to the given XID, failing if
+// it was moved in the meantime.
+func (c Client) xGroupCursorAdvanceAction(key string, group string, from XID, to XID) types.TransactWriteItem {
 	builder := newExpresionBuilder()
-	builder.updateSET(vk, StringValue{id.String()})
-	builder.addConditionLessThan(vk, StringValue{id.String()})
+	builder.updateSET(vk, StringValue{to.String()})
+	builder.addConditionEquality(vk, StringValue{from.String()})
 
 	return types.TransactWriteItem{
 		Update: &types.Update{
@@ -695,52 +1746,117 @@ func (c Client) xGroupReadPending(key string, group string, consumer string, cou
 	return
 }
 
+// XREADGROUP reads up to maxCount items for consumer in the group. With XReadNew and XReadNewAutoACK, the items
+// after the group's cursor are delivered and the cursor is moved past them in a single conditional write, so
+// concurrent consumers never receive the same items; XReadNew also records the items as pending for the consumer,
+// to be acknowledged with XACK. With XReadPending, the items already pending for the consumer are delivered again.
+//
+// The cursor is moved in the same transaction as the first transactionActions - 1 pending entries, and the rest
+// of the pending entries are written in further transactions of up to transactionActions entries. Those writes
+// are retried with a backoff when they conflict or are throttled, as the items are already delivered once the
+// cursor has moved; if one still fails, the error is returned along with all the items, so that none of them
+// are lost, but the ones in the failed and later transactions aren't recorded as pending.
+//
+// Cost is O(N) / 1 WCU for each item delivered with XReadNew, plus the size of the items read.
+//
+// Works similar to https://redis.io/commands/xreadgroup
 func (c Client) XREADGROUP(key string, group string, consumer string, option XReadOption, maxCount int32) (items []StreamItem, err error) {
 	if option == XReadPending {
 		return c.xGroupReadPending(key, group, consumer, maxCount)
 	}
 
-	retryCount := 0
+	for retryCount := 0; retryCount < xContentionRetries; retryCount++ {
+		var currentCursor XID
 
-	for retryCount < 5 {
-		currentCursor, err := c.xGroupCursorGet(key, group)
+		currentCursor, err = c.xGroupCursorGet(key, group)
 		if err != nil {
-			return items, err
+			return nil, err
 		}
 
-		items, err := c.XRANGE(key, currentCursor.Next(), XEnd, 1)
-
+		items, err = c.XRANGE(key, currentCursor.Next(), XEnd, maxCount)
 		if err != nil || len(items) == 0 {
 			return items, err
 		}
 
-		item := items[0]
-
-		var actions []types.TransactWriteItem
-		actions = append(actions, c.xGroupCursorPushAction(key, group, item.ID))
+		var pendingActions []types.TransactWriteItem
 
 		if option == XReadNew {
-			actions = append(actions, PendingItem{
-				ID:            item.ID,
-				Consumer:      consumer,
-				LastDelivered: time.Now(),
-			}.toPutAction(c.xGroupKey(key, group), c))
+			now := time.Now()
+
+			for _, item := range items {
+				pendingActions = append(pendingActions, PendingItem{
+					ID:            item.ID,
+					Consumer:      consumer,
+					LastDelivered: now,
+				}.toPutAction(c.xGroupKey(key, group), c))
+			}
+		}
+
+		first := len(pendingActions)
+		if first > c.transactionActions-1 {
+			first = c.transactionActions - 1
 		}
 
+		actions := append([]types.TransactWriteItem{
+			c.xGroupCursorAdvanceAction(key, group, currentCursor, items[len(items)-1].ID),
+		}, pendingActions[:first]...)
+
 		_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
 			TransactItems: actions,
 		})
-		if err == nil {
-			return items, nil
+
+		if conditionFailureError(err) {
+			// another consumer moved the cursor in the meantime.
+			continue
 		}
 
-		if !conditionFailureError(err) {
-			return items, err
+		if err != nil {
+			return nil, err
 		}
-		retryCount++
+
+		return items, c.xWritePending(pendingActions[first:])
 	}
 
-	return items, errors.New("too much contention")
+	return nil, errors.New("too much contention")
+}
+
+// xWritePending writes the given pending entries in transactions of up to transactionActions entries. A
+// transaction that failed because of a conflict or throttling is retried after a backoff, up to
+// xContentionRetries times; any other error is returned at once.
+func (c Client) xWritePending(actions []types.TransactWriteItem) (err error) {
+	for len(actions) > 0 {
+		chunk := actions
+		if len(chunk) > c.transactionActions {
+			chunk = chunk[:c.transactionActions]
+		}
+
+		backoff := batchMinBackoff
+
+		for retryCount := 0; ; retryCount++ {
+			_, err = c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{
+				TransactItems: chunk,
+			})
+
+			if !conditionFailureError(err) && !throttlingError(err) || retryCount+1 >= xContentionRetries {
+				break
+			}
+
+			time.Sleep(backoff)
+
+			backoff *= 2
+			if backoff > batchMaxBackoff {
+				backoff = batchMaxBackoff
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		actions = actions[len(chunk):]
+	}
+
+	return nil
 }
 
 // XREVRANGE is similar to XRANGE, but in reverse order. The stream items in descending chronological order. Using the
@@ -758,25 +1874,109 @@ func (c Client) XREVRANGE(key string, end, start XID, count int32) (streamItems
 	return c.xRange(key, start, end, count, false)
 }
 
+// XTRIM trims the stream at key down to its newest newCount items, deleting the older ones, and returns the
+// number of items deleted. It's a shorthand for XTRIMWITH(key, XMaxLen(newCount)).
+//
+// Works similar to https://redis.io/commands/xtrim
 func (c Client) XTRIM(key string, newCount int32) (deletedCount int32, err error) {
+	return c.XTRIMWITH(key, XMaxLen(newCount))
+}
+
+// xTrimApproximateLimit is the default number of items an approximate trim deletes at most.
+const xTrimApproximateLimit = 100
+
+// XTrim describes how a stream is trimmed by XTRIMWITH and XADDTRIM. Create one with XMaxLen or XMinID.
+type XTrim struct {
+	maxLen      int32
+	minID       XID
+	approximate bool
+	limit       int32
+}
+
+// XMaxLen trims a stream down to its newest maxLen items (MAXLEN).
+func XMaxLen(maxLen int32) XTrim {
+	return XTrim{maxLen: maxLen}
+}
+
+// XMinID trims the items with XIDs lower than minID from a stream (MINID). The ID may be given in the Redis form
+// as well, see ParseXID; XTRIMWITH rejects invalid IDs.
+func XMinID(minID XID) XTrim {
+	return XTrim{minID: minID}
+}
+
+// Approximate makes the trim delete at most limit items (100 if limit is zero), even if that leaves the stream
+// longer than requested, like MAXLEN ~ and MINID ~ with LIMIT. This bounds the work of each call, so a
+// producer can trim the stream on every XADDTRIM and let it converge over the following calls.
+func (t XTrim) Approximate(limit int32) XTrim {
+	if limit <= 0 {
+		limit = xTrimApproximateLimit
+	}
+
+	t.approximate, t.limit = true, limit
+
+	return t
+}
+
+// XTRIMWITH trims the stream at key as described by trim, and returns the number of items deleted. The oldest
+// items are always deleted first.
+//
+// Cost is O(N) / 2 WCUs for each deleted item, in transactions of up to 99 items, plus 1 RCU for MAXLEN trims.
+//
+// Works similar to https://redis.io/commands/xtrim
+func (c Client) XTRIMWITH(key string, trim XTrim) (deletedCount int32, err error) {
+	if trim.minID != "" {
+		minID, err := ParseXID(trim.minID.String())
+		if err != nil {
+			return 0, err
+		}
+
+		if minID == XAutoID {
+			return 0, errors.New("MINID can't be an automatic stream ID")
+		}
+
+		return c.xTrimOldest(key, minID, trim.limit)
+	}
+
+	length, err := c.XLEN(key, XStart, XEnd)
+	if err != nil || length <= trim.maxLen {
+		return 0, err
+	}
+
+	excess := length - trim.maxLen
+	if trim.approximate && excess > trim.limit {
+		excess = trim.limit
+	}
+
+	return c.xTrimOldest(key, XEnd, excess)
+}
+
+// xTrimOldest deletes up to limit of the oldest items of the stream at key with XIDs lower than before, or all
+// of them if limit is zero.
+func (c Client) xTrimOldest(key string, before XID, limit int32) (deletedCount int32, err error) {
 	hasMoreResults := true
 
 	var cursor map[string]types.AttributeValue
 
-	for hasMoreResults {
+	for hasMoreResults && (limit <= 0 || deletedCount < limit) {
 		builder := newExpresionBuilder()
 		builder.addConditionEquality(c.partitionKey, StringValue{key})
-		builder.condition(fmt.Sprintf("#%v BETWEEN :start AND :stop", c.sortKey), c.sortKey)
-		builder.values["start"] = XStart.av()
-		builder.values["stop"] = XEnd.av()
+		builder.condition(fmt.Sprintf("#%v < :before", c.sortKey), c.sortKey)
+		builder.values["before"] = before.av()
+
+		var queryLimit *int32
+		if limit > 0 {
+			queryLimit = aws.Int32(limit - deletedCount)
+		}
+
 		resp, err := c.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
 			ConsistentRead:            aws.Bool(c.consistentReads),
 			ExclusiveStartKey:         cursor,
 			ExpressionAttributeNames:  builder.expressionAttributeNames(),
 			ExpressionAttributeValues: builder.expressionAttributeValues(),
 			KeyConditionExpression:    builder.conditionExpression(),
+			Limit:                     queryLimit,
 			ProjectionExpression:      aws.String(strings.Join([]string{c.partitionKey, c.sortKey}, ",")),
-			ScanIndexForward:          aws.Bool(false),
+			ScanIndexForward:          aws.Bool(true),
 			TableName:                 aws.String(c.tableName),
 		})
 
@@ -790,24 +1990,16 @@ func (c Client) XTRIM(key string, newCount int32) (deletedCount int32, err error
 			hasMoreResults = false
 		}
 
-		var idsToDelete []XID
-
+		ids := make([]XID, 0, len(resp.Items))
 		for _, item := range resp.Items {
-			if newCount == 0 {
-				parsedItem := parseKey(item, c)
-				idsToDelete = append(idsToDelete, XID(parsedItem.sk))
-			} else {
-				newCount--
-			}
+			ids = append(ids, XID(parseKey(item, c).sk))
 		}
 
-		if len(idsToDelete) > 0 {
-			deletedCount += int32(len(idsToDelete))
-			_, err = c.XDEL(key, idsToDelete...)
+		deleted, err := c.xDelete(key, ids)
+		deletedCount += int32(len(deleted))
 
-			if err != nil {
-				return deletedCount, err
-			}
+		if err != nil {
+			return deletedCount, err
 		}
 	}
 
@@ -1,10 +1,14 @@
 package redimo
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -107,6 +111,166 @@ func TestStreamDeletes(t *testing.T) {
 	assert.Equal(t, insertID5, items[1].ID)
 }
 
+func TestStreamTrimming(t *testing.T) {
+	c := newClient(t)
+
+	var ids []XID
+
+	for i := int64(1); i <= 10; i++ {
+		id, err := c.XADDTRIM("x1", NewXID(time.Unix(i, 0), 0), map[string]Value{"i": IntValue{i}}, XMaxLen(8))
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	count, err := c.XLEN("x1", XStart, XEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8), count)
+
+	items, err := c.XRANGE("x1", XStart, XEnd, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[2], items[0].ID)
+
+	deletedCount, err := c.XTRIMWITH("x1", XMaxLen(2).Approximate(4))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), deletedCount)
+
+	deletedCount, err = c.XTRIMWITH("x1", XMaxLen(2).Approximate(4))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), deletedCount)
+
+	items, err = c.XRANGE("x1", XStart, XEnd, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []XID{ids[8], ids[9]}, []XID{items[0].ID, items[1].ID})
+
+	for i := int64(11); i <= 15; i++ {
+		id, err := c.XADD("x1", NewXID(time.Unix(i, 0), 0), map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	for _, invalid := range []XID{"*", "not-an-id", "12-"} {
+		_, err = c.XTRIMWITH("x1", XMinID(invalid))
+		assert.Error(t, err, invalid)
+	}
+
+	deletedCount, err = c.XTRIMWITH("x1", XMinID(XID(ids[12].RedisString())))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), deletedCount)
+
+	_, err = c.XADDTRIM("x1", XAutoID, map[string]Value{"i": IntValue{16}}, XMinID(ids[14]).Approximate(1))
+	assert.NoError(t, err)
+
+	count, err = c.XLEN("x1", XStart, XEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), count)
+}
+
+func TestStreamDelete(t *testing.T) {
+	c := newClient(t)
+
+	for i := int64(1); i <= 3; i++ {
+		_, err := c.XADD("x1", XAutoID, map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, c.XGROUP("x1", "group", XStart))
+
+	_, err := c.XREADGROUP("x1", "group", "alice", XReadNew, 2)
+	assert.NoError(t, err)
+
+	_, err = c.DEL("x1")
+	assert.NoError(t, err)
+
+	count, err := c.XLEN("x1", XStart, XEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), count)
+
+	groups, err := c.XINFOGROUPS("x1")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	pending, err := c.XPENDING("x1", "group", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// the stream starts over, so smaller IDs than before are accepted.
+	_, err = c.XADD("x1", "1-0", map[string]Value{"i": IntValue{4}})
+	assert.NoError(t, err)
+
+	count, err = c.XLEN("x1", XStart, XEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), count)
+}
+
+func TestStreamInfo(t *testing.T) {
+	c := newClient(t)
+	key := "x1"
+
+	info, err := c.XINFOSTREAM(key)
+	assert.NoError(t, err)
+	assert.Equal(t, XStreamInfo{}, info)
+
+	var ids []XID
+
+	for i := int64(0); i < 5; i++ {
+		id, err := c.XADD(key, XAutoID, map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	deleted, err := c.XDEL(key, ids[1], ids[1], "00000000000000000001-00000000000000000000")
+	assert.NoError(t, err)
+	assert.Equal(t, []XID{ids[1]}, deleted)
+
+	count, err := c.XLEN(key, XStart, XEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), count)
+
+	_, err = c.XTRIM(key, 3)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.XGROUP(key, "readers", XStart))
+	assert.NoError(t, c.XGROUP(key, "archivers", XStart))
+
+	info, err = c.XINFOSTREAM(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), info.Length)
+	assert.Equal(t, int32(2), info.Groups)
+	assert.Equal(t, ids[4], info.LastGeneratedID)
+	assert.Equal(t, ids[2], info.FirstEntry.ID)
+	assert.Equal(t, ids[4], info.LastEntry.ID)
+	assert.Equal(t, int64(4), info.LastEntry.Fields["i"].Int())
+
+	_, err = c.XREADGROUP(key, "readers", "alice", XReadNew, 1)
+	assert.NoError(t, err)
+	_, err = c.XREADGROUP(key, "readers", "bob", XReadNew, 1)
+	assert.NoError(t, err)
+	_, err = c.XREADGROUP(key, "readers", "alice", XReadNew, 1)
+	assert.NoError(t, err)
+
+	groups, err := c.XINFOGROUPS(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []XGroupInfo{
+		{Name: "archivers", LastDeliveredID: XStart},
+		{Name: "readers", Consumers: 2, Pending: 3, LastDeliveredID: ids[4]},
+	}, groups)
+
+	consumers, err := c.XINFOCONSUMERS(key, "readers")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(consumers))
+	assert.Equal(t, "alice", consumers[0].Name)
+	assert.Equal(t, int32(2), consumers[0].Pending)
+	assert.Equal(t, "bob", consumers[1].Name)
+	assert.Equal(t, int32(1), consumers[1].Pending)
+	assert.True(t, consumers[1].Idle < time.Minute)
+
+	_, err = c.XINFOCONSUMERS(key, "nobody")
+	assert.Equal(t, ErrXGroupNotInitialized, err)
+}
+
 func TestStreamsConsumerGroupsNoACK(t *testing.T) {
 	c := newClient(t)
 	allItems := make([]StreamItem, 0, 25)
@@ -271,9 +435,334 @@ func TestStreamsConsumerGroupACK(t *testing.T) {
 	assert.Equal(t, consumer1, pendingItems[0].Consumer)
 }
 
+func TestStreamsConsumerGroupBatchRead(t *testing.T) {
+	c := newClient(t).TransactionActions(4)
+	key := "x1"
+	group := "group"
+
+	var ids []XID
+
+	for i := int64(0); i < 12; i++ {
+		id, err := c.XADD(key, XAutoID, map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	assert.NoError(t, c.XGROUP(key, group, XStart))
+
+	items, err := c.XREADGROUP(key, group, "alice", XReadNew, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(items))
+	assert.Equal(t, ids[0], items[0].ID)
+	assert.Equal(t, ids[9], items[9].ID)
+
+	pendingItems, err := c.XPENDING(key, group, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(pendingItems))
+
+	items, err = c.XREADGROUP(key, group, "bob", XReadNewAutoACK, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []XID{ids[10], ids[11]}, []XID{items[0].ID, items[1].ID})
+
+	items, err = c.XREADGROUP(key, group, "bob", XReadNew, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	pendingItems, err = c.XPENDING(key, group, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(pendingItems))
+
+	items, err = c.XREADGROUP(key, group, "alice", XReadPending, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, ids[0], items[0].ID)
+}
+
+func TestStreamAutoClaim(t *testing.T) {
+	c := newClient(t)
+	key := "x1"
+	group := "group"
+
+	var ids []XID
+
+	for i := int64(0); i < 4; i++ {
+		id, err := c.XADD(key, XAutoID, map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	assert.NoError(t, c.XGROUP(key, group, XStart))
+
+	items, err := c.XREADGROUP(key, group, "crashed", XReadNew, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[0], items[0].ID)
+	items, err = c.XREADGROUP(key, group, "crashed", XReadNew, 1)
+	assert.NoError(t, err)
+	items, err = c.XREADGROUP(key, group, "crashed", XReadNew, 1)
+	assert.NoError(t, err)
+	items, err = c.XREADGROUP(key, group, "healthy", XReadNew, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[3], items[0].ID)
+
+	pendingItems, err := c.XPENDINGWITH(key, group, XPendingOptions{Consumer: "crashed"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pendingItems))
+
+	pendingItems, err = c.XPENDINGWITH(key, group, XPendingOptions{Start: ids[1], End: ids[2]}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []XID{ids[1], ids[2]}, []XID{pendingItems[0].ID, pendingItems[1].ID})
+
+	pendingItems, err = c.XPENDINGWITH(key, group, XPendingOptions{MinIdle: time.Hour}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pendingItems)
+
+	next, items, deleted, err := c.XAUTOCLAIM(key, group, "rescuer", time.Hour, XStart, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, XStart, next)
+	assert.Empty(t, items)
+	assert.Empty(t, deleted)
+
+	next, items, deleted, err = c.XAUTOCLAIM(key, group, "rescuer", 0, XStart, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[2], next)
+	assert.Equal(t, []XID{ids[0], ids[1]}, []XID{items[0].ID, items[1].ID})
+	assert.Empty(t, deleted)
+
+	_, err = c.XDEL(key, ids[2])
+	assert.NoError(t, err)
+
+	next, items, deleted, err = c.XAUTOCLAIM(key, group, "rescuer", 0, next, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, XStart, next)
+	assert.Equal(t, ids[3], items[0].ID)
+	assert.Equal(t, []XID{ids[2]}, deleted)
+
+	pendingItems, err = c.XPENDINGWITH(key, group, XPendingOptions{Consumer: "rescuer"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(pendingItems))
+	assert.Equal(t, int32(2), pendingItems[0].DeliveryCount)
+
+	pendingItems, err = c.XPENDINGWITH(key, group, XPendingOptions{Consumer: "crashed"}, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pendingItems)
+
+	_, _, _, err = c.XAUTOCLAIM(key, "nobody", "rescuer", 0, XStart, 1)
+	assert.Equal(t, ErrXGroupNotInitialized, err)
+}
+
+func TestStreamsConsumerGroupManagement(t *testing.T) {
+	c := newClient(t)
+	key := "x1"
+	group := "group"
+
+	var ids []XID
+
+	for i := int64(0); i < 5; i++ {
+		id, err := c.XADD(key, XAutoID, map[string]Value{"i": IntValue{i}})
+		assert.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	assert.Equal(t, ErrXGroupNotInitialized, c.XGROUPSETID(key, group, XStart))
+
+	_, err := c.XGROUPCREATECONSUMER(key, group, "alice")
+	assert.Equal(t, ErrXGroupNotInitialized, err)
+
+	assert.NoError(t, c.XGROUP(key, group, XStart))
+
+	created, err := c.XGROUPCREATECONSUMER(key, group, "alice")
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	created, err = c.XGROUPCREATECONSUMER(key, group, "alice")
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	consumers, err := c.XINFOCONSUMERS(key, group)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(consumers))
+	assert.Equal(t, "alice", consumers[0].Name)
+	assert.Equal(t, int32(0), consumers[0].Pending)
+
+	_, err = c.XREADGROUP(key, group, "bob", XReadNew, 3)
+	assert.NoError(t, err)
+
+	items, err := c.XREADGROUP(key, group, "carol", XReadNew, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[3], items[0].ID)
+
+	pending, err := c.XGROUPDELCONSUMER(key, group, "bob", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), pending)
+
+	pending, err = c.XGROUPDELCONSUMER(key, group, "carol", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), pending)
+
+	consumers, err = c.XINFOCONSUMERS(key, group)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(consumers))
+	assert.Equal(t, "alice", consumers[0].Name)
+	assert.Equal(t, int32(3), consumers[0].Pending)
+
+	assert.NoError(t, c.XGROUPSETID(key, group, ids[0]))
+
+	items, err = c.XREADGROUP(key, group, "dave", XReadNewAutoACK, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(items))
+	assert.Equal(t, ids[1], items[0].ID)
+
+	ok, err := c.XGROUPDESTROY(key, group)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = c.XGROUPDESTROY(key, group)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	pendingItems, err := c.XPENDING(key, group, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pendingItems)
+
+	groups, err := c.XINFOGROUPS(key)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+
+	_, err = c.XREADGROUP(key, group, "alice", XReadNew, 1)
+	assert.Equal(t, ErrXGroupNotInitialized, err)
+}
+
 func TestXIDGeneration(t *testing.T) {
-	assert.Equal(t, "00000000000000012345-00000000000000000000", NewTimeXID(time.Unix(12345, 0)).First().String())
-	assert.Equal(t, "00000000000000012345-99999999999999999999", NewTimeXID(time.Unix(12345, 0)).Last().String())
+	assert.Equal(t, "00000001526919030474-00000000000000000000", NewTimeXID(time.Unix(1526919030, 474000000)).First().String())
+	assert.Equal(t, "00000001526919030474-99999999999999999999", NewTimeXID(time.Unix(1526919030, 474000000)).Last().String())
+
+	xid := NewXID(time.Unix(1526919030, 474000000), 55)
+	assert.Equal(t, "1526919030474-55", xid.RedisString())
+	assert.Equal(t, time.Unix(1526919030, 474000000), xid.Time())
+	assert.Equal(t, "1526919030474-56", xid.Next().RedisString())
+	assert.Equal(t, "1526919030474-54", xid.Prev().RedisString())
+
+	parsed, err := ParseXID("1526919030474-55")
+	assert.NoError(t, err)
+	assert.Equal(t, xid, parsed)
+
+	parsed, err = ParseXID(xid.String())
+	assert.NoError(t, err)
+	assert.Equal(t, xid, parsed)
+
+	parsed, err = ParseXID("1526919030474")
+	assert.NoError(t, err)
+	assert.Equal(t, "1526919030474-0", parsed.RedisString())
+
+	for id, expected := range map[string]XID{"-": XStart, "+": XEnd, "*": XAutoID} {
+		parsed, err = ParseXID(id)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, parsed)
+	}
+
+	for _, invalid := range []string{"", "abc", "12-", "-12", "1-2-3", "1.5-2", "123456789012345678901-0", "18446744073709551616-0"} {
+		_, err = ParseXID(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// small timestamps are still millisecond XIDs.
+	for id, expected := range map[string]time.Time{"0-1": time.Unix(0, 0), "1-0": time.Unix(0, 1000000)} {
+		parsed, err = ParseXID(id)
+		assert.NoError(t, err)
+		assert.Equal(t, id, parsed.RedisString())
+		assert.Equal(t, expected, parsed.Time())
+	}
+
+	assert.Equal(t, "1000-0", NewXID(time.Unix(1, 0), 0).RedisString())
+
+	legacy := XID("00000000001526919030-00000000000000000007")
+	assert.Equal(t, XID("00000001526919030000-00000000000000000007"), legacy.Migrated())
+	assert.Equal(t, time.Unix(1526919030, 0), legacy.Migrated().Time())
+	assert.Equal(t, "1526919030000-7", legacy.Migrated().RedisString())
+	assert.Equal(t, XID("00000000001526919030-00000000000000000008"), legacy.Next())
+}
+
+func TestXIDMigration(t *testing.T) {
+	c := newClient(t)
+
+	legacy1 := XID("00000000001526919030-00000000000000000001")
+	legacy2 := XID("00000000001526919031-00000000000000000002")
+
+	// write the stream the way older versions did: the items, and a sequence item with only the last XID.
+	writeLegacy := func(key string, ids ...XID) {
+		sequence := xSequenceKey(key).toAV(c)
+		sequence[vk] = StringValue{ids[len(ids)-1].String()}.ToAV()
+
+		actions := []types.TransactWriteItem{{Put: &types.Put{Item: sequence, TableName: aws.String(c.tableName)}}}
+		for _, id := range ids {
+			actions = append(actions, StreamItem{ID: id, Fields: map[string]ReturnValue{"id": {StringValue{id.String()}.ToAV()}}}.putAction(key, c))
+		}
+
+		_, err := c.ddbClient.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{TransactItems: actions})
+		assert.NoError(t, err)
+	}
+
+	writeLegacy("x1", legacy1, legacy2)
+
+	id3, err := c.XADD("x1", "1526919032000-3", map[string]Value{"id": StringValue{"third"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1526919032000-3", id3.RedisString())
+
+	_, err = c.XADD("x1", "not-an-id", map[string]Value{})
+	assert.Error(t, err)
+
+	end, ok, err := c.XLEGACYEND("x1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, legacy2, end)
+
+	// streams created by this version have no legacy XIDs, however small their XIDs are.
+	_, err = c.XADD("x2", "1-0", map[string]Value{"id": StringValue{"small"}})
+	assert.NoError(t, err)
+
+	_, ok, err = c.XLEGACYEND("x2")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	migrated, err := c.XMIGRATEIDS("x2")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	migrated, err = c.XMIGRATEIDS("x1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+
+	items, err := c.XRANGE("x1", XStart, XEnd, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, []string{"1526919030000-1", "1526919031000-2", "1526919032000-3"},
+		[]string{items[0].ID.RedisString(), items[1].ID.RedisString(), items[2].ID.RedisString()})
+	assert.Equal(t, legacy1.String(), items[0].Fields["id"].String())
+
+	_, ok, err = c.XLEGACYEND("x1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	migrated, err = c.XMIGRATEIDS("x1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	// an item that already has the migrated XID is never overwritten.
+	writeLegacy("x3", legacy1)
+
+	_, err = c.XADD("x3", legacy1.Migrated(), map[string]Value{"id": StringValue{"new"}})
+	assert.NoError(t, err)
+
+	_, err = c.XMIGRATEIDS("x3")
+	assert.Error(t, err)
+
+	items, err = c.XRANGE("x3", XStart, XEnd, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []XID{legacy1, legacy1.Migrated()}, []XID{items[0].ID, items[1].ID})
+	assert.Equal(t, "new", items[1].Fields["id"].String())
 }
 
 func TestRanges(t *testing.T) {